@@ -2,30 +2,30 @@ package fiohealth
 
 import (
 	"encoding/json"
-	"fmt"
 	"strings"
 	"sync"
 	"time"
 )
 
-type alarmType uint8
-
-const (
-	health alarmType = iota
-	security
-)
-
-// ApiAlertState holds the alarm status for a node
+// ApiAlertState holds the alarm status for a node. The unexported fields track what happened during this run, an alarm
+// is raised or cleared when the state changes.
 type ApiAlertState struct {
-	sendAlarm bool
+	healthFailed    bool // a health finding was added during this run
+	healthRaised    bool // the health alarm started during this run, and was not suppressed
+	healthCleared   bool // the health alarm ended during this run
+	securityFailed  bool
+	securityRaised  bool
+	securityCleared bool
 
-	HealthAlarm     bool      `json:"health_alarm"`
-	HealthReason    string    `json:"health_reason"`
-	HealthNotBefore time.Time `json:"health_not_before"`
+	HealthAlarm     bool       `json:"health_alarm"`
+	HealthReason    string     `json:"health_reason"`
+	HealthFindings  []*Finding `json:"health_findings,omitempty"`
+	HealthNotBefore time.Time  `json:"health_not_before"`
 
-	SecurityAlarm     bool      `json:"security_alarm"`
-	SecurityReason    string    `json:"security_reason"`
-	SecurityNotBefore time.Time `json:"security_not_before"`
+	SecurityAlarm     bool       `json:"security_alarm"`
+	SecurityReason    string     `json:"security_reason"`
+	SecurityFindings  []*Finding `json:"security_findings,omitempty"`
+	SecurityNotBefore time.Time  `json:"security_not_before"`
 }
 
 // ApiAlerts contains all api alarms, is marshalled and stored to reduce alarm fatigue
@@ -42,102 +42,133 @@ func UnmarshalApiAlerts(b []byte) (*ApiAlerts, error) {
 	return aa, err
 }
 
-// shouldAlarm determines if a new alarm should be generated, should be called *before* updating state.
-func (aa *ApiAlerts) shouldAlarm(host string, alarm alarmType) bool {
-	if aa.State[host] == nil || aa.State[host].sendAlarm {
-		return true
+// state gets the alarm state for a host, adding it if needed. The lock must be held.
+func (aa *ApiAlerts) state(host string) *ApiAlertState {
+	if aa.State == nil {
+		aa.State = make(map[string]*ApiAlertState)
 	}
-	switch alarm {
-	case health:
-		if time.Now().UTC().Before(aa.State[host].HealthNotBefore) {
-			return false
-		}
-	case security:
-		if time.Now().UTC().Before(aa.State[host].SecurityNotBefore) {
-			return false
-		}
+	if aa.State[host] == nil {
+		aa.State[host] = &ApiAlertState{}
 	}
-	return true
+	return aa.State[host]
 }
 
-// HealthOk resets the health state for an endpoint
+// HealthOk clears the health alarm for an endpoint, unless a health check failed for it during this run
 func (aa *ApiAlerts) HealthOk(host string) {
 	aa.Lock()
 	defer aa.Unlock()
-	if aa.State[host] == nil {
-		aa.State[host] = &ApiAlertState{}
+	state := aa.state(host)
+	if state.healthFailed {
+		return
 	}
-	aa.State[host].HealthAlarm = false
-	aa.State[host].HealthReason = ""
+	state.healthCleared = state.HealthAlarm
+	state.HealthAlarm = false
+	state.HealthReason = ""
+	state.HealthFindings = nil
 }
 
-// SecurityOk resets the security state for an endpoint
+// SecurityOk clears the security alarm for an endpoint, unless a security check failed for it during this run
 func (aa *ApiAlerts) SecurityOk(host string) {
 	aa.Lock()
 	defer aa.Unlock()
-	if aa.State[host] == nil {
-		aa.State[host] = &ApiAlertState{}
+	state := aa.state(host)
+	if state.securityFailed {
+		return
 	}
-	aa.State[host].SecurityAlarm = false
-	aa.State[host].SecurityReason = ""
+	state.securityCleared = state.SecurityAlarm
+	state.SecurityAlarm = false
+	state.SecurityReason = ""
+	state.SecurityFindings = nil
 }
 
-// GetAlarms provides a list of alarms that need to be sent to telegram
+// GetAlarms provides a list of alarms that need to be sent to telegram, these are the raised Events
 func (aa *ApiAlerts) GetAlarms() []string {
+	return alarmTitles(aa.Events())
+}
+
+// Events lists the alarms raised (the same ones sent to telegram) and cleared during this run
+func (aa *ApiAlerts) Events() []*AlertEvent {
 	aa.Lock()
 	defer aa.Unlock()
-	alarms := make([]string, 0)
+	events := make([]*AlertEvent, 0)
 	for k, v := range aa.State {
-		if v.sendAlarm {
-			if v.HealthAlarm {
-				alarms = append(alarms, fmt.Sprintf("Health warning: %s - %s", k, v.HealthReason))
-			}
-			if v.SecurityAlarm && !v.HealthAlarm {
-				alarms = append(alarms, fmt.Sprintf("Security warning: %s - %s", k, v.SecurityReason))
-			}
+		switch {
+		case v.healthRaised && v.HealthAlarm:
+			events = append(events, &AlertEvent{Node: k, Kind: AlertHealth, Raised: true, Reason: v.HealthReason, Findings: v.HealthFindings})
+		case v.healthCleared:
+			events = append(events, &AlertEvent{Node: k, Kind: AlertHealth})
+		}
+		switch {
+		case v.securityRaised && v.SecurityAlarm:
+			events = append(events, &AlertEvent{Node: k, Kind: AlertSecurity, Raised: true, Reason: v.SecurityReason, Findings: v.SecurityFindings})
+		case v.securityCleared:
+			events = append(events, &AlertEvent{Node: k, Kind: AlertSecurity})
 		}
 	}
-	return alarms
+	sortEvents(events)
+	return events
 }
 
-// HostFailed saves a failure into alarm state, calls shouldAlarm to mark as needing an alert
-func (aa *ApiAlerts) HostFailed(host string, why string, healthOrSecurity alarmType, suppress int) {
+// HostFailed saves a finding into alarm state. The finding's category determines if it is a health or security alarm,
+// the reason is a summary of all of the findings in this run. The alarm is raised if it was not already active, and
+// the last failure was more than suppress hours ago.
+func (aa *ApiAlerts) HostFailed(host string, f *Finding, suppress int) {
 	aa.Lock()
 	defer aa.Unlock()
-	nb := time.Now().UTC().Add(time.Duration(suppress) * time.Hour)
-	if aa.State[host] == nil {
-		aa.State[host] = &ApiAlertState{}
-	}
-	aa.State[host].sendAlarm = aa.shouldAlarm(host, healthOrSecurity)
-	// alert repeatedly on impending TLS expiration
-	//if why == "cert expires in 1 days" {
-	//	aa.State[host].sendAlarm = true
-	//}
-	switch healthOrSecurity {
-	case health:
-		aa.State[host].HealthAlarm = true
-		aa.State[host].HealthNotBefore = nb
-		if strings.Contains(aa.State[host].HealthReason, why) {
-			return
+	now := time.Now().UTC()
+	state := aa.state(host)
+	switch f.Category {
+	case CategorySecurity:
+		if !state.securityFailed {
+			state.securityFailed = true
+			state.securityRaised = !state.SecurityAlarm && !now.Before(state.SecurityNotBefore)
+			state.SecurityReason = ""
+			state.SecurityFindings = nil
 		}
-		if aa.State[host].HealthReason != "" {
-			aa.State[host].HealthReason = aa.State[host].HealthReason + "; " + why
+		state.SecurityAlarm = true
+		state.SecurityNotBefore = now.Add(time.Duration(suppress) * time.Hour)
+		if sameFinding(state.SecurityFindings, f) {
 			return
 		}
-		aa.State[host].HealthReason = why
-	case security:
-		aa.State[host].SecurityAlarm = true
-		aa.State[host].SecurityNotBefore = nb
-		if strings.Contains(aa.State[host].SecurityReason, why) {
-			return
+		state.SecurityFindings = append(state.SecurityFindings, f)
+		state.SecurityReason = joinReasons(state.SecurityReason, f.Message)
+	default:
+		if !state.healthFailed {
+			state.healthFailed = true
+			state.healthRaised = !state.HealthAlarm && !now.Before(state.HealthNotBefore)
+			state.HealthReason = ""
+			state.HealthFindings = nil
 		}
-		if aa.State[host].SecurityReason != "" {
-			aa.State[host].SecurityReason = aa.State[host].SecurityReason + "; " + why
+		state.HealthAlarm = true
+		state.HealthNotBefore = now.Add(time.Duration(suppress) * time.Hour)
+		if sameFinding(state.HealthFindings, f) {
 			return
 		}
-		aa.State[host].SecurityReason = why
+		state.HealthFindings = append(state.HealthFindings, f)
+		state.HealthReason = joinReasons(state.HealthReason, f.Message)
 	}
-	return
+}
+
+// alarmTitles gives the notification text for each raised event
+func alarmTitles(events []*AlertEvent) []string {
+	alarms := make([]string, 0)
+	for _, e := range events {
+		if e.Raised {
+			alarms = append(alarms, e.Title())
+		}
+	}
+	return alarms
+}
+
+// joinReasons appends to the text summary of findings used in notifications
+func joinReasons(reason string, why string) string {
+	if reason == "" {
+		return why
+	}
+	if strings.Contains(reason, why) {
+		return reason
+	}
+	return reason + "; " + why
 }
 
 // ToJson marshals
@@ -147,13 +178,16 @@ func (aa *ApiAlerts) ToJson() ([]byte, error) {
 	return json.MarshalIndent(aa, "", "  ")
 }
 
-// P2pAlertState represents the alarm state for a p2p node
+// P2pAlertState represents the alarm state for a p2p node, the unexported fields track what happened during this run
 type P2pAlertState struct {
-	sendAlarm bool
+	failed    bool // a finding was added during this run
+	raised    bool // the alarm started during this run, and was not suppressed
+	recovered bool // the alarm was cleared during this run
 
-	Alarm     bool      `json:"alarm"`
-	Reason    string    `json:"reason"`
-	NotBefore time.Time `json:"not_before"`
+	Alarm     bool       `json:"alarm"`
+	Reason    string     `json:"reason"`
+	Findings  []*Finding `json:"findings,omitempty"`
+	NotBefore time.Time  `json:"not_before"`
 }
 
 // P2pAlerts holds all the p2p alarms, and is stored each run to reduce alarm fatigue
@@ -172,63 +206,73 @@ func UnmarshalP2pAlerts(b []byte) (*P2pAlerts, error) {
 	return pa, err
 }
 
-// shouldAlarm determines if a new alarm should be generated, should be called *before* updating state.
-func (pa *P2pAlerts) shouldAlarm(host string) bool {
-	if pa.State[host] == nil {
-		return true
+// state gets the alarm state for a host, adding it if needed. The lock must be held.
+func (pa *P2pAlerts) state(host string) *P2pAlertState {
+	if pa.State == nil {
+		pa.State = make(map[string]*P2pAlertState)
 	}
-	if time.Now().UTC().Before(pa.State[host].NotBefore) {
-		return false
+	if pa.State[host] == nil {
+		pa.State[host] = &P2pAlertState{}
 	}
-	return true
+	return pa.State[host]
 }
 
-// HostOk resets a p2p node to healthy state
+// HostOk clears the alarm for a p2p node, unless it failed during this run
 func (pa *P2pAlerts) HostOk(host string) {
 	pa.Lock()
 	defer pa.Unlock()
-	if pa.State[host] == nil {
-		pa.State[host] = &P2pAlertState{}
+	state := pa.state(host)
+	if state.failed {
+		return
 	}
-	pa.State[host].Alarm = false
-	pa.State[host].Reason = ""
+	state.recovered = state.Alarm
+	state.Alarm = false
+	state.Reason = ""
+	state.Findings = nil
 }
 
-// HostFailed stores a test failure
-func (pa *P2pAlerts) HostFailed(host string, reason string, suppression int) (shouldAlert bool) {
+// HostFailed stores a test failure, the alarm is raised if it was not already active, and the last failure was more
+// than suppression hours ago.
+func (pa *P2pAlerts) HostFailed(host string, f *Finding, suppression int) (shouldAlert bool) {
 	pa.Lock()
 	defer pa.Unlock()
-	if pa.State == nil {
-		pa.State = make(map[string]*P2pAlertState)
+	now := time.Now().UTC()
+	state := pa.state(host)
+	if !state.failed {
+		state.failed = true
+		state.raised = !state.Alarm && !now.Before(state.NotBefore)
+		state.Reason = ""
+		state.Findings = nil
 	}
-	if pa.State[host] == nil {
-		pa.State[host] = &P2pAlertState{}
-	}
-	pa.State[host].sendAlarm = pa.shouldAlarm(host)
-	pa.State[host].Alarm = true
-	pa.State[host].NotBefore = time.Now().UTC().Add(time.Duration(suppression) * time.Hour)
-	if strings.Contains(pa.State[host].Reason, reason) {
-		return
+	state.Alarm = true
+	state.NotBefore = now.Add(time.Duration(suppression) * time.Hour)
+	if !sameFinding(state.Findings, f) {
+		state.Findings = append(state.Findings, f)
+		state.Reason = joinReasons(state.Reason, f.Message)
 	}
-	if pa.State[host].Reason != "" {
-		pa.State[host].Reason = pa.State[host].Reason + "; " + reason
-		return
-	}
-	pa.State[host].Reason = reason
-	return
+	return state.raised
 }
 
-// GetAlarms returns all of the new failures that need alerting
+// GetAlarms returns all of the new failures that need alerting, these are the raised Events
 func (pa *P2pAlerts) GetAlarms() []string {
+	return alarmTitles(pa.Events())
+}
+
+// Events lists the alarms raised (the same ones sent to telegram) and cleared during this run
+func (pa *P2pAlerts) Events() []*AlertEvent {
 	pa.Lock()
 	defer pa.Unlock()
-	alarms := make([]string, 0)
+	events := make([]*AlertEvent, 0)
 	for k, v := range pa.State {
-		if v.sendAlarm {
-			alarms = append(alarms, fmt.Sprintf("P2P health warning: %s - %s", k, v.Reason))
+		switch {
+		case v.raised && v.Alarm:
+			events = append(events, &AlertEvent{Node: k, Kind: AlertP2p, Raised: true, Reason: v.Reason, Findings: v.Findings})
+		case v.recovered:
+			events = append(events, &AlertEvent{Node: k, Kind: AlertP2p})
 		}
 	}
-	return alarms
+	sortEvents(events)
+	return events
 }
 
 // ToJson marshals the alerts
@@ -0,0 +1,142 @@
+package fiohealth
+
+import (
+	"testing"
+)
+
+// reload saves the alarm state and reads it back, as happens between runs
+func reload(t *testing.T, aa *ApiAlerts) *ApiAlerts {
+	b, err := aa.ToJson()
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := UnmarshalApiAlerts(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return next
+}
+
+func eventKinds(events []*AlertEvent) map[string]bool {
+	kinds := make(map[string]bool)
+	for _, e := range events {
+		if e.Raised {
+			kinds["raised "+e.Kind] = true
+		} else {
+			kinds["cleared "+e.Kind] = true
+		}
+	}
+	return kinds
+}
+
+func expectEvents(t *testing.T, run string, events []*AlertEvent, expected ...string) {
+	kinds := eventKinds(events)
+	if len(kinds) != len(expected) || len(events) != len(expected) {
+		t.Errorf("%s: expected %v, got %v", run, expected, kinds)
+		return
+	}
+	for _, e := range expected {
+		if !kinds[e] {
+			t.Errorf("%s: expected %v, got %v", run, expected, kinds)
+		}
+	}
+}
+
+func TestApiAlertTransitions(t *testing.T) {
+	const host = "https://fio.example.com"
+	down := &Finding{Check: "get_info", Category: CategoryHealth, Severity: SeverityCritical, Message: "connection refused"}
+	exposed := &Finding{Check: "exposed_endpoint", Category: CategorySecurity, Severity: SeverityCritical, Message: "net api is enabled"}
+
+	// both alarms are raised
+	aa := &ApiAlerts{}
+	aa.HostFailed(host, down, 0)
+	aa.HostFailed(host, exposed, 0)
+	aa.HealthOk(host)
+	aa.SecurityOk(host)
+	expectEvents(t, "first failure", aa.Events(), "raised "+AlertHealth, "raised "+AlertSecurity)
+	if len(aa.GetAlarms()) != 2 {
+		t.Errorf("expected 2 notifications, got %v", aa.GetAlarms())
+	}
+
+	// still down after a restart, but the security problem is fixed: only the security alarm clears
+	aa = reload(t, aa)
+	aa.HostFailed(host, down, 0)
+	aa.HealthOk(host)
+	aa.SecurityOk(host)
+	expectEvents(t, "still down", aa.Events(), "cleared "+AlertSecurity)
+	if len(aa.GetAlarms()) != 0 {
+		t.Errorf("an active alarm should not be sent again, got %v", aa.GetAlarms())
+	}
+	if !aa.State[host].HealthAlarm || aa.State[host].SecurityAlarm {
+		t.Error("expected only the health alarm to be active")
+	}
+
+	// recovered after a restart
+	aa = reload(t, aa)
+	aa.HealthOk(host)
+	aa.SecurityOk(host)
+	expectEvents(t, "recovered", aa.Events(), "cleared "+AlertHealth)
+	if aa.State[host].HealthAlarm || aa.State[host].HealthReason != "" {
+		t.Error("the health alarm should be cleared")
+	}
+
+	// nothing changes after another restart
+	aa = reload(t, aa)
+	aa.HealthOk(host)
+	aa.SecurityOk(host)
+	expectEvents(t, "healthy", aa.Events())
+
+	// fails again, and is raised again
+	aa = reload(t, aa)
+	aa.HostFailed(host, down, 0)
+	aa.HealthOk(host)
+	expectEvents(t, "second failure", aa.Events(), "raised "+AlertHealth)
+}
+
+func TestApiAlertFlapSuppression(t *testing.T) {
+	const host = "https://fio.example.com"
+	down := &Finding{Check: "get_info", Category: CategoryHealth, Severity: SeverityCritical, Message: "connection refused"}
+
+	aa := &ApiAlerts{}
+	aa.HostFailed(host, down, 4)
+	expectEvents(t, "failure", aa.Events(), "raised "+AlertHealth)
+	aa = reload(t, aa)
+	aa.HealthOk(host)
+	expectEvents(t, "recovered", aa.Events(), "cleared "+AlertHealth)
+	aa = reload(t, aa)
+	aa.HostFailed(host, down, 4)
+	expectEvents(t, "failed within the suppression window", aa.Events())
+	if !aa.State[host].HealthAlarm {
+		t.Error("the alarm should be active even when the notification is suppressed")
+	}
+}
+
+func TestP2pAlertTransitions(t *testing.T) {
+	const host = "fio.example.com:9876"
+	f := &Finding{Check: "connection", Category: CategoryHealth, Severity: SeverityCritical, Message: "connection refused"}
+	reload := func(pa *P2pAlerts) *P2pAlerts {
+		b, err := pa.ToJson()
+		if err != nil {
+			t.Fatal(err)
+		}
+		next, err := UnmarshalP2pAlerts(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return next
+	}
+
+	pa := &P2pAlerts{}
+	pa.HostFailed(host, f, 0)
+	pa.HostOk(host)
+	expectEvents(t, "failure", pa.Events(), "raised "+AlertP2p)
+	pa = reload(pa)
+	pa.HostFailed(host, f, 0)
+	expectEvents(t, "still down", pa.Events())
+	pa = reload(pa)
+	pa.HostOk(host)
+	expectEvents(t, "recovered", pa.Events(), "cleared "+AlertP2p)
+	pa = reload(pa)
+	pa.HostOk(host)
+	expectEvents(t, "healthy", pa.Events())
+}
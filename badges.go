@@ -0,0 +1,165 @@
+package fiohealth
+
+import (
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+)
+
+// badge colors, the same as shields.io
+const (
+	badgeGreen  = "#4c1"
+	badgeYellow = "#dfb317"
+	badgeRed    = "#e05d44"
+	badgeGrey   = "#9f9f9f"
+)
+
+// badgeMinUptime is the uptime (percent) below which a node that is currently up is shown in yellow
+const badgeMinUptime = 95
+
+// Badges renders an SVG badge showing the status, uptime, and latency for each node, and a summary for the network
+// named network.svg. Node badges use the same names as the node pages, they are written to badges/ after each run.
+func (d *ReportData) Badges() map[string][]byte {
+	badges := make(map[string][]byte)
+	for _, p := range d.Nodes {
+		var (
+			label, latency string
+			up             bool
+		)
+		switch {
+		case p.Api != nil:
+			label, up, latency = "fio api", !p.Api.HadError, fmt.Sprintf("%d ms", p.Api.RequestLatency)
+		case p.P2p != nil:
+			label, up, latency = "fio p2p", p.P2p.Healthy, fmt.Sprintf("lag %d ms", p.P2p.HeadBlockLatency)
+		case p.History != nil:
+			label, up, latency = "fio history", !p.History.HadError, fmt.Sprintf("%d ms", p.History.RequestLatency)
+		default:
+			continue
+		}
+		uptime := d.Uptime(p.Kind, p.Node)
+		badges[p.FileName()+".svg"] = badgeSvg(label, nodeBadgeMessage(up, uptime, latency), nodeBadgeColor(up, uptime))
+	}
+	badges["network.svg"] = d.networkBadge()
+	return badges
+}
+
+func nodeBadgeMessage(up bool, uptime *NodeUptime, latency string) string {
+	status := "down"
+	if up {
+		status = "up"
+	}
+	parts := []string{status}
+	if uptime != nil && uptime.Checks > 0 {
+		parts = append(parts, fmt.Sprintf("%.1f%%", uptime.Percent))
+	}
+	if up {
+		parts = append(parts, latency)
+	}
+	return strings.Join(parts, " | ")
+}
+
+func nodeBadgeColor(up bool, uptime *NodeUptime) string {
+	switch {
+	case !up:
+		return badgeRed
+	case uptime != nil && uptime.Checks > 0 && uptime.Percent < badgeMinUptime:
+		return badgeYellow
+	}
+	return badgeGreen
+}
+
+// networkBadge shows how many API and p2p nodes are up, the uptime for every check over the recent runs, and the
+// median response time of the API nodes that are up.
+func (d *ReportData) networkBadge() []byte {
+	var nodes, up, checks, passed int
+	latencies := make([]int64, 0)
+	for _, r := range d.Api {
+		nodes += 1
+		if !r.HadError {
+			up += 1
+			latencies = append(latencies, r.RequestLatency)
+		}
+	}
+	for _, p := range d.P2p {
+		nodes += 1
+		if p.Healthy {
+			up += 1
+		}
+	}
+	for _, p := range d.Nodes {
+		if p.Kind == "history" {
+			continue
+		}
+		for _, u := range p.Uptime {
+			checks += u.Checks
+			passed += u.Passed
+		}
+	}
+
+	label := "fio " + strings.ToLower(d.Title)
+	if nodes == 0 {
+		return badgeSvg(label, "no nodes", badgeGrey)
+	}
+	parts := []string{fmt.Sprintf("%d/%d up", up, nodes)}
+	uptime := float64(100)
+	if checks > 0 {
+		uptime = float64(passed*1000/checks) / 10
+		parts = append(parts, fmt.Sprintf("%.1f%%", uptime))
+	}
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool {
+			return latencies[i] < latencies[j]
+		})
+		parts = append(parts, fmt.Sprintf("%d ms", latencies[len(latencies)/2]))
+	}
+	color := badgeGreen
+	switch {
+	case up*2 < nodes:
+		color = badgeRed
+	case up < nodes || uptime < badgeMinUptime:
+		color = badgeYellow
+	}
+	return badgeSvg(label, strings.Join(parts, " | "), color)
+}
+
+// badgeTextWidth estimates the width of text in 11px Verdana, textLength in the svg makes it exact
+func badgeTextWidth(s string) int {
+	var w float64
+	for _, c := range s {
+		switch {
+		case strings.ContainsRune("iljtf.,:;|!' ", c):
+			w += 3.8
+		case strings.ContainsRune("mwMW%", c):
+			w += 10
+		case c >= 'A' && c <= 'Z':
+			w += 7.5
+		default:
+			w += 6.8
+		}
+	}
+	return int(w + 0.5)
+}
+
+// badgeSvg draws a flat badge, like those from shields.io, with the label on grey and the message on the color
+func badgeSvg(label string, message string, color string) []byte {
+	lw, mw := badgeTextWidth(label)+10, badgeTextWidth(message)+10
+	label, message = html.EscapeString(label), html.EscapeString(message)
+	return []byte(fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[3]s: %[4]s">
+  <title>%[3]s: %[4]s</title>
+  <linearGradient id="s" x2="0" y2="100%%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+  <clipPath id="r"><rect width="%[1]d" height="20" rx="3" fill="#fff"/></clipPath>
+  <g clip-path="url(#r)">
+    <rect width="%[2]d" height="20" fill="#555"/>
+    <rect x="%[2]d" width="%[6]d" height="20" fill="%[5]s"/>
+    <rect width="%[1]d" height="20" fill="url(#s)"/>
+  </g>
+  <g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+    <text x="%[7]d" y="15" fill="#010101" fill-opacity=".3" textLength="%[8]d">%[3]s</text>
+    <text x="%[7]d" y="14" textLength="%[8]d">%[3]s</text>
+    <text x="%[9]d" y="15" fill="#010101" fill-opacity=".3" textLength="%[10]d">%[4]s</text>
+    <text x="%[9]d" y="14" textLength="%[10]d">%[4]s</text>
+  </g>
+</svg>
+`, lw+mw, lw, label, message, color, mw, lw/2, lw-10, lw+mw/2, mw-10))
+}
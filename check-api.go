@@ -5,197 +5,101 @@ import (
 	"crypto/tls"
 	"fmt"
 	"github.com/fioprotocol/fio-go"
+	"github.com/fioprotocol/fio-go/eos"
 	"log"
 	"math"
+	"net"
+	"net/http"
+	"net/url"
 	"strings"
+	"sync"
 	"time"
 )
 
 // CheckApis runs the health checks for the API nodes, each node is tested concurrently, timeouts are set for a short
 // interval. Checks: connection latency, head block lag, chainId is correct, logs (and checks) for expected version,
-// if CORS is permissive, that TLS is enabled, checks for weak TLS ciphers and deprecated version, ensures that
-// the negotiated protocol is TLSv1.2 or higher, alarms if certificate expires within 30 days, and ensures that neither
-// the producer and network API is exposed.
+// if CORS is permissive, security headers and preflight handling, that TLS is enabled, checks for weak TLS ciphers
+// and deprecated version, ensures that the negotiated protocol is TLSv1.2 or higher, alarms if certificate expires
+// within 30 days, validates the full certificate chain and revocation status, and ensures that none of the sensitive
+// endpoints in sensitiveEndpoints are exposed. If resolve_all is set, and a hostname has more than one address, each
+// address is also tested. If dual_stack is set, reachability is also tested using only IPv4 and only IPv6, and
+// if dns_check is set the hostname's DNS records are checked.
 func CheckApis(conf *Config) (report []*Result) {
 
-	done := make(chan interface{})
+	// buffered so that checks finishing after the deadline don't block forever
+	done := make(chan *apiDone, len(conf.ApiNodes))
 	pending := len(conf.ApiNodes)
-	ctx, _ := context.WithDeadline(context.Background(), time.Now().Add(time.Minute))
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(apiCheckTimeout))
+	defer cancel()
 
-	myIpAddr, err := MyGeo(conf.Geolite)
-	if err != nil {
-		log.Fatal(err)
-	}
+	myIpAddr := conf.TestOrigin()
 	results := make([]*Result, len(conf.ApiNodes))
 	for i, a := range conf.ApiNodes {
+		// reported if the check doesn't finish before the deadline
+		results[i] = &Result{
+			Type:      "api",
+			Node:      a,
+			TimeStamp: time.Now().UTC().Unix(),
+			FromGeo:   myIpAddr,
+			HadError:  true,
+			Error:     "timed out",
+			ErrorFor:  "api check",
+		}
 		go func(i int, a string) {
+			var r *Result
 			defer func() {
-				done <-true
+				done <- &apiDone{i: i, r: r}
 			}()
-			results[i] = &Result{
-				Type:      "api",
-				Node:      a,
-				TimeStamp: time.Now().UTC().Unix(),
-				FromGeo:   myIpAddr,
-			}
-			api, _, err := fio.NewConnection(nil, a)
-			if err != nil {
-				log.Println(a, "new connection", err.Error())
-				emsg := err.Error()
-				switch true {
-				case strings.HasSuffix(emsg, "timeout"):
-					emsg = "connection timeout"
-				case strings.HasSuffix(emsg, "no such host"):
-					emsg = "name lookup failed"
-				case strings.HasSuffix(emsg, "connection reset by peer"):
-					emsg = "connection refused"
-				}
-				results[i].HadError = true
-				results[i].Error = emsg
-				results[i].ErrorFor = "initial connection"
-				results[i].Score += 10
-				conf.ApiAlerts.HostFailed(a, emsg, health, conf.FlapSuppression)
-				return
-			}
-			before := time.Now().UTC()
-			gi, err := api.GetInfo()
-			now := time.Now().UTC()
-			results[i].RequestLatency = now.Sub(before).Milliseconds()
-			if err != nil {
-				log.Println(a, "get info", err.Error())
-				results[i].HadError = true
-				results[i].Error = err.Error()
-				results[i].ErrorFor = "get info"
-				results[i].Score += 10
-				conf.ApiAlerts.HostFailed(a, err.Error(), health, conf.FlapSuppression)
-				return
-			}
-			results[i].HeadBlockLatency = now.Sub(gi.HeadBlockTime.Time).Milliseconds()
-			results[i].NodeVer = gi.ServerVersionString
-			if !strings.HasPrefix(results[i].NodeVer, conf.ExpectedVersionPrefix) {
-				results[i].WrongVersion = true
-			}
-			if gi.HeadBlockTime.Time.Before(time.Now().UTC().Add(-30 * time.Second)) {
-				log.Println(a, "is not synced!")
-				emsg := fmt.Sprintf("node head block is behind by %.2f", now.Sub(gi.HeadBlockTime.Time).Seconds())
-				results[i].HadError = true
-				results[i].Error = emsg
-				results[i].ErrorFor = "get info"
-				results[i].Score += 1
-				conf.ApiAlerts.HostFailed(a, emsg, health, conf.FlapSuppression)
-			}
-			if gi.ChainID.String() != conf.ChainId {
-				log.Println(a, "Wrong chain!")
-				results[i].HadError = true
-				results[i].Error = "wrong chain"
-				results[i].ErrorFor = "get info"
-				results[i].Score += 5
-				conf.ApiAlerts.HostFailed(a, "wrong chain", health, conf.FlapSuppression)
-			}
-			_, err = api.GetBlockByNum(gi.LastIrreversibleBlockNum)
-			if err != nil {
-				log.Println(a, "get block", err.Error())
-				results[i].HadError = true
-				results[i].Error = err.Error()
-				results[i].ErrorFor = "get block"
-				results[i].Score += 10
-				conf.ApiAlerts.HostFailed(a, err.Error(), health, conf.FlapSuppression)
-				return
-			}
-
-			notes := make([]string, 0)
-
-			if finding, found := TestTls(api.BaseURL, conf.Debug); found {
-				notes = append(notes, finding)
-				results[i].Score += 1
-			} else if strings.HasPrefix(api.BaseURL, "https") {
-				results[i].TlsCipherOk = true
-			}
-
-			// going to use native http lib here to get access to response headers and TLS info:
-			resp, err := api.HttpClient.Get(api.BaseURL + "/v1/chain/get_producer_schedule")
-			if err != nil {
-				log.Println(a, "producer schedule", err.Error())
-				results[i].HadError = true
-				results[i].Error = err.Error()
-				results[i].ErrorFor = "get producer schedule"
-				results[i].Score += 10
-				conf.ApiAlerts.HostFailed(a, err.Error(), health, conf.FlapSuppression)
-			}
-			if resp == nil {
-				return
-			}
-			_ = resp.Body.Close()
-
-			if resp.Header.Get("Access-Control-Allow-Origin") == "*" {
-				results[i].PermissiveCors = true
-			} else {
-				results[i].Score += 1
-				conf.ApiAlerts.HostFailed(a, "missing permissive CORS header", health, conf.FlapSuppression)
-			}
-
-			if resp.TLS != nil {
-				appendIf := func(n string) {
-					if n != "" {
-						notes = append(notes, n)
+			var addresses []*Result
+			wg := sync.WaitGroup{}
+			if conf.ResolveAll {
+				if u, err := url.Parse(a); err == nil {
+					ips, _ := ResolveAll(u.Hostname())
+					if len(ips) > 1 {
+						addresses = make([]*Result, len(ips))
+						wg.Add(len(ips))
+						for j := range ips {
+							go func(j int) {
+								defer wg.Done()
+								addresses[j] = ApiCheck(a, ips[j], myIpAddr, conf)
+							}(j)
+						}
 					}
 				}
-
-				if resp.TLS.Version >= tls.VersionTLS12 {
-					results[i].TlsVerOk = true
-				} else {
-					appendIf("negotiated TLS version < 1.2")
-					results[i].Score += 1
-				}
-				if len(resp.TLS.PeerCertificates) > 0 && resp.TLS.PeerCertificates[0] != nil {
-					expires := resp.TLS.PeerCertificates[0].NotAfter.Sub(time.Now().UTC()).Hours() / 24
-					if expires < 30 {
-						appendIf(fmt.Sprintf("cert expires in %d days", int64(math.Round(expires))))
-						results[i].Score += .1
-					}
-				}
-				results[i].TlsNote = strings.Join(notes, ", ")
-			} else {
-				results[i].TlsNote = "TLS not enabled"
-				results[i].Score += 1
-			}
-
-			// should always get an error, if not network/producer api is exposed:
-			_, err = api.GetNetConnections()
-			if err == nil {
-				log.Println(a, "net api")
-				results[i].NetExposed = true
-				results[i].Score += 3
-				conf.ApiAlerts.HostFailed(a, "net api is enabled", security, conf.FlapSuppression)
-			}
-			_, err = api.IsProducerPaused()
-			if err == nil {
-				log.Println(a, "producer api")
-				results[i].ProducerExposed = true
-				results[i].Score += 3
-				conf.ApiAlerts.HostFailed(a, "producer api is enabled", security, conf.FlapSuppression)
 			}
-			if len(notes) > 0 {
-				conf.ApiAlerts.HostFailed(a, strings.Join(notes, ", "), security, conf.FlapSuppression)
+			var v4, v6 *FamilyResult
+			if conf.DualStack {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					v4, v6 = apiDualStack(a, conf)
+				}()
 			}
-			conf.ApiAlerts.RLock()
-			if conf.ApiAlerts.State[a] != nil && !conf.ApiAlerts.State[a].HealthAlarm {
-				conf.ApiAlerts.RUnlock()
-				conf.ApiAlerts.HealthOk(a)
-				conf.ApiAlerts.RLock()
+			var dnsResult *DnsResult
+			if conf.DnsCheck {
+				if u, err := url.Parse(a); err == nil {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						dnsResult = DnsCheck(u.Hostname(), u.Scheme == "https", conf.DnsResolver)
+					}()
+				}
 			}
-			if conf.ApiAlerts.State[a] != nil && !conf.ApiAlerts.State[a].SecurityAlarm {
-				conf.ApiAlerts.RUnlock()
-				conf.ApiAlerts.SecurityOk(a)
-			} else {
-				conf.ApiAlerts.RUnlock()
+			r = ApiCheck(a, "", myIpAddr, conf)
+			wg.Wait()
+			r.Addresses = addresses
+			r.Ipv4, r.Ipv6 = v4, v6
+			r.Dns = dnsResult
+			if r.Error == "name lookup failed" && dnsResult != nil && dnsResult.Status != "ok" {
+				r.Error += " (" + dnsResult.Status + ")"
 			}
 		}(i, a)
 	}
 
 	for {
 		select {
-		case <-done:
+		case d := <-done:
+			results[d.i] = d.r
 			pending -= 1
 			if pending == 0 {
 				conf.Log("all API nodes tested, API check completed")
@@ -207,3 +111,278 @@ func CheckApis(conf *Config) (report []*Result) {
 		}
 	}
 }
+
+// apiCheckTimeout limits how long CheckApis waits for all of the nodes
+var apiCheckTimeout = time.Minute
+
+// apiDone is sent by each of CheckApis' goroutines when its check is finished
+type apiDone struct {
+	i int
+	r *Result
+}
+
+// ApiCheck runs the checks for a single API node. If ip is not empty all connections are made to that address,
+// otherwise the hostname is resolved normally.
+func ApiCheck(a string, ip string, geo string, conf *Config) *Result {
+	r := &Result{
+		Type:      "api",
+		Node:      a,
+		Ip:        ip,
+		TimeStamp: time.Now().UTC().Unix(),
+		FromGeo:   geo,
+	}
+	// alarms for individual addresses are tracked separately from the hostname
+	alarmKey := a
+	if ip != "" {
+		alarmKey = a + " (" + ip + ")"
+	}
+	rules := conf.Rules(a)
+	// alert records a finding that should raise an alarm
+	alert := func(f *Finding, points float32) {
+		conf.ApiAlerts.HostFailed(alarmKey, r.Add(f, points), conf.FlapSuppression)
+	}
+	api, err := newApi(a, ip)
+	if err != nil {
+		log.Println(a, "new connection", err.Error())
+		emsg := err.Error()
+		switch true {
+		case strings.HasSuffix(emsg, "timeout"):
+			emsg = "connection timeout"
+		case strings.HasSuffix(emsg, "no such host"):
+			emsg = "name lookup failed"
+		case strings.HasSuffix(emsg, "connection reset by peer"):
+			emsg = "connection refused"
+		}
+		r.HadError = true
+		r.Error = emsg
+		r.ErrorFor = "initial connection"
+		alert(&Finding{Check: "connection", Category: CategoryHealth, Severity: SeverityCritical, Message: emsg}, rules.Api.Connection)
+		return r
+	}
+	before := time.Now().UTC()
+	gi, err := api.GetInfo()
+	now := time.Now().UTC()
+	r.RequestLatency = now.Sub(before).Milliseconds()
+	if err != nil {
+		log.Println(a, "get info", err.Error())
+		r.HadError = true
+		r.Error = err.Error()
+		r.ErrorFor = "get info"
+		alert(&Finding{Check: "get_info", Category: CategoryHealth, Severity: SeverityCritical, Message: err.Error()}, rules.Api.Connection)
+		return r
+	}
+	r.HeadBlockLatency = now.Sub(gi.HeadBlockTime.Time).Milliseconds()
+	r.Slow = r.RequestLatency > rules.SlowResponse
+	if r.Slow {
+		r.Add(&Finding{
+			Check:     "response_time",
+			Category:  CategoryHealth,
+			Severity:  SeverityInfo,
+			Message:   "slow response",
+			Observed:  fmt.Sprintf("%d ms", r.RequestLatency),
+			Threshold: fmt.Sprintf("%d ms", rules.SlowResponse),
+		}, 0)
+	}
+	r.NodeVer = gi.ServerVersionString
+	if !strings.HasPrefix(r.NodeVer, conf.ExpectedVersionPrefix) {
+		r.WrongVersion = true
+		r.Add(&Finding{
+			Check:     "version",
+			Category:  CategoryHealth,
+			Severity:  SeverityInfo,
+			Message:   "unexpected version",
+			Observed:  r.NodeVer,
+			Threshold: conf.ExpectedVersionPrefix,
+		}, 0)
+	}
+	if gi.HeadBlockTime.Time.Before(time.Now().UTC().Add(-time.Duration(rules.HeadBlockLag) * time.Second)) {
+		r.Lagging = true
+		log.Println(a, "is not synced!")
+		emsg := fmt.Sprintf("node head block is behind by %.2f", now.Sub(gi.HeadBlockTime.Time).Seconds())
+		r.HadError = true
+		r.Error = emsg
+		r.ErrorFor = "get info"
+		alert(&Finding{
+			Check:     "head_block_lag",
+			Category:  CategoryHealth,
+			Severity:  SeverityWarning,
+			Message:   emsg,
+			Observed:  fmt.Sprintf("%.2f s", now.Sub(gi.HeadBlockTime.Time).Seconds()),
+			Threshold: fmt.Sprintf("%d s", rules.HeadBlockLag),
+		}, rules.Api.Lagging)
+	}
+	if gi.ChainID.String() != conf.ChainId {
+		log.Println(a, "Wrong chain!")
+		r.HadError = true
+		r.Error = "wrong chain"
+		r.ErrorFor = "get info"
+		alert(&Finding{
+			Check:     "chain_id",
+			Category:  CategoryHealth,
+			Severity:  SeverityCritical,
+			Message:   "wrong chain",
+			Observed:  gi.ChainID.String(),
+			Threshold: conf.ChainId,
+		}, rules.Api.WrongChain)
+	}
+	_, err = api.GetBlockByNum(gi.LastIrreversibleBlockNum)
+	if err != nil {
+		log.Println(a, "get block", err.Error())
+		r.HadError = true
+		r.Error = err.Error()
+		r.ErrorFor = "get block"
+		alert(&Finding{Check: "get_block", Category: CategoryHealth, Severity: SeverityCritical, Message: err.Error()}, rules.Api.Connection)
+		return r
+	}
+
+	notes := make([]string, 0)
+
+	r.TlsMatrix = ScanTls(api.BaseURL, ip, conf.Debug)
+	if weaknesses := r.TlsMatrix.Weaknesses(); len(weaknesses) > 0 {
+		notes = append(notes, weaknesses...)
+		alert(&Finding{Check: "tls_support", Category: CategorySecurity, Severity: SeverityWarning, Message: strings.Join(weaknesses, ", ")}, rules.Api.Tls)
+	} else if strings.HasPrefix(api.BaseURL, "https") {
+		r.TlsCipherOk = true
+	}
+
+	if r.Cert = AuditCertificate(api.BaseURL, ip, rules.CertExpiryDays); r.Cert != nil {
+		c := r.Cert
+		switch true {
+		case c.Unchecked:
+			// a failed handshake is already reported by the connection and TLS checks, it says nothing about the cert
+			r.Add(&Finding{Check: "certificate", Category: CategorySecurity, Severity: SeverityWarning, Message: "certificate not checked: " + strings.Join(c.Notes, ", ")}, 0)
+		case c.Revocation == "revoked", !c.ChainOk && !c.IncompleteChain, !c.HostnameOk:
+			alert(&Finding{Check: "certificate", Category: CategorySecurity, Severity: SeverityCritical, Message: strings.Join(c.Notes, ", ")}, rules.Api.CertInvalid)
+		case !c.Ok():
+			r.Add(&Finding{Check: "certificate", Category: CategorySecurity, Severity: SeverityWarning, Message: strings.Join(c.Notes, ", ")}, rules.Api.CertWarning)
+		}
+	}
+
+	// going to use native http lib here to get access to response headers and TLS info:
+	resp, err := api.HttpClient.Get(api.BaseURL + "/v1/chain/get_producer_schedule")
+	if err != nil {
+		log.Println(a, "producer schedule", err.Error())
+		r.HadError = true
+		r.Error = err.Error()
+		r.ErrorFor = "get producer schedule"
+		alert(&Finding{Check: "get_producer_schedule", Category: CategoryHealth, Severity: SeverityCritical, Message: err.Error()}, rules.Api.Connection)
+	}
+	if resp == nil {
+		return r
+	}
+	_ = resp.Body.Close()
+
+	if resp.Header.Get("Access-Control-Allow-Origin") == "*" {
+		r.PermissiveCors = true
+	} else {
+		alert(&Finding{
+			Check:     "cors",
+			Category:  CategoryHealth,
+			Severity:  SeverityWarning,
+			Message:   "missing permissive CORS header",
+			Observed:  resp.Header.Get("Access-Control-Allow-Origin"),
+			Threshold: "*",
+		}, rules.Api.Cors)
+	}
+
+	r.Headers = AuditHeaders(api.HttpClient, api.BaseURL, resp.Header)
+	for _, finding := range r.Headers {
+		if !finding.Ok {
+			r.Add(&Finding{
+				Check:    "header_" + strings.ToLower(strings.ReplaceAll(finding.Name, "-", "_")),
+				Category: CategorySecurity,
+				Severity: SeverityInfo,
+				Message:  finding.Name + ": " + finding.Note,
+				Observed: finding.Value,
+			}, rules.Api.Header)
+		}
+	}
+
+	if resp.TLS != nil {
+		appendIf := func(n string) {
+			if n != "" {
+				notes = append(notes, n)
+			}
+		}
+
+		if resp.TLS.Version >= tls.VersionTLS12 {
+			r.TlsVerOk = true
+		} else {
+			appendIf("negotiated TLS version < 1.2")
+			alert(&Finding{
+				Check:     "tls_version",
+				Category:  CategorySecurity,
+				Severity:  SeverityWarning,
+				Message:   "negotiated TLS version < 1.2",
+				Observed:  versionNames[resp.TLS.Version],
+				Threshold: "TLS 1.2",
+			}, rules.Api.Tls)
+		}
+		if len(resp.TLS.PeerCertificates) > 0 && resp.TLS.PeerCertificates[0] != nil {
+			expires := resp.TLS.PeerCertificates[0].NotAfter.Sub(time.Now().UTC()).Hours() / 24
+			if expires < float64(rules.CertExpiryDays) {
+				msg := fmt.Sprintf("cert expires in %d days", int64(math.Round(expires)))
+				appendIf(msg)
+				alert(&Finding{
+					Check:     "cert_expiry",
+					Category:  CategorySecurity,
+					Severity:  SeverityWarning,
+					Message:   msg,
+					Observed:  fmt.Sprintf("%d days", int64(math.Round(expires))),
+					Threshold: fmt.Sprintf("%d days", rules.CertExpiryDays),
+				}, rules.Api.CertExpiring)
+			}
+		}
+		r.TlsNote = strings.Join(notes, ", ")
+	} else {
+		r.TlsNote = "TLS not enabled"
+		r.Add(&Finding{Check: "tls_enabled", Category: CategorySecurity, Severity: SeverityWarning, Message: "TLS not enabled"}, rules.Api.Tls)
+	}
+
+	// none of these should respond, if they do a sensitive plugin is exposed:
+	r.Security = ProbeEndpoints(api.HttpClient, api.BaseURL)
+	for _, finding := range r.Security {
+		if finding.Exposed {
+			log.Println(a, finding.Name)
+			alert(&Finding{
+				Check:    "exposed_endpoint",
+				Category: CategorySecurity,
+				Severity: SeverityCritical,
+				Message:  finding.Name + " is enabled",
+				Observed: fmt.Sprintf("%s returned %d", finding.Endpoint, finding.Status),
+			}, rules.Api.Exposed)
+		}
+	}
+	// these only clear the alarms if nothing failed above
+	conf.ApiAlerts.HealthOk(alarmKey)
+	conf.ApiAlerts.SecurityOk(alarmKey)
+	return r
+}
+
+// newApi connects to an API node, if an address is provided all connections are made to that address, while still
+// using the hostname for TLS SNI and the Host header.
+func newApi(node string, ip string) (*fio.API, error) {
+	if ip == "" {
+		api, _, err := fio.NewConnection(nil, node)
+		return api, err
+	}
+	return newApiDialer(node, pinnedDial(ip))
+}
+
+// newApiDialer is the same as fio.NewConnection, but uses dial for all connections.
+func newApiDialer(node string, dial func(ctx context.Context, network string, addr string) (net.Conn, error)) (*fio.API, error) {
+	// eos.API holds a mutex, so copy the fields eos.New sets instead of the struct
+	e := eos.New(node)
+	if tr, ok := e.HttpClient.Transport.(*http.Transport); ok {
+		tr.DialContext = dial
+	}
+	api := &fio.API{}
+	api.HttpClient = e.HttpClient
+	api.BaseURL = e.BaseURL
+	api.Compress = e.Compress
+	api.Header = e.Header
+	api.Header.Set("User-Agent", "fio-go")
+	// fio.NewConnection does the same to ensure the node is reachable
+	_, err := api.GetInfo()
+	return api, err
+}
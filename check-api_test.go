@@ -0,0 +1,49 @@
+package fiohealth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCheckApisDeadline(t *testing.T) {
+	release := make(chan struct{})
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer hung.Close()
+	defer close(release)
+
+	timeout := apiCheckTimeout
+	apiCheckTimeout = 200 * time.Millisecond
+	defer func() { apiCheckTimeout = timeout }()
+
+	conf := &Config{
+		ApiNodes:  []string{hung.URL, "http://127.0.0.1:1"},
+		Origin:    "test",
+		ApiAlerts: &ApiAlerts{State: make(map[string]*ApiAlertState)},
+	}
+	started := time.Now()
+	results := CheckApis(conf)
+	if time.Since(started) > 5*time.Second {
+		t.Fatal("CheckApis did not stop at the deadline")
+	}
+	if len(results) != len(conf.ApiNodes) {
+		t.Fatalf("expected %d results, got %d", len(conf.ApiNodes), len(results))
+	}
+	for i, r := range results {
+		if r == nil {
+			t.Fatalf("result %d is nil", i)
+		}
+		if r.Node != conf.ApiNodes[i] || !r.HadError {
+			t.Errorf("result %d: expected an error for %s, got %+v", i, conf.ApiNodes[i], r)
+		}
+	}
+	if results[0].Error != "timed out" {
+		t.Errorf("expected the hung node to time out, got %q", results[0].Error)
+	}
+	if results[1].Error == "timed out" {
+		t.Error("the refused connection should have finished before the deadline")
+	}
+}
@@ -0,0 +1,214 @@
+package fiohealth
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ocsp"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// AuditCertificate validates the certificate presented by an https endpoint. Unlike ScanTls this uses the standard
+// library handshake and checks the full chain against the system roots, the hostname, whether the server omits intermediate
+// certificates, key strength and signature algorithms, and revocation status using the stapled OCSP response or
+// by querying the responder listed in the certificate. If ip is set the connection is made to that address.
+// Intermediates expiring within expiryDays are noted.
+func AuditCertificate(uri string, ip string, expiryDays int) *CertAudit {
+	if !strings.HasPrefix(uri, "https") {
+		return nil
+	}
+	audit := &CertAudit{Revocation: "unchecked"}
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		audit.VerifyError = err.Error()
+		audit.Notes = append(audit.Notes, "could not parse url")
+		audit.Unchecked = true
+		return audit
+	}
+	hostname := parsed.Hostname()
+	port := parsed.Port()
+	if port == "" {
+		port = "443"
+	}
+
+	// verification is done after the handshake so that a bad cert can still be inspected
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 5 * time.Second}, "tcp", dialAddress(hostname, ip, port), &tls.Config{
+		ServerName:         hostname,
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		audit.VerifyError = err.Error()
+		audit.Notes = append(audit.Notes, "TLS connection failed")
+		audit.Unchecked = true
+		return audit
+	}
+	state := conn.ConnectionState()
+	_ = conn.Close()
+	if len(state.PeerCertificates) == 0 {
+		audit.Notes = append(audit.Notes, "no certificate presented")
+		audit.Unchecked = true
+		return audit
+	}
+	leaf := state.PeerCertificates[0]
+
+	for i, cert := range state.PeerCertificates {
+		days := int64(math.Round(cert.NotAfter.Sub(time.Now().UTC()).Hours() / 24))
+		audit.Chain = append(audit.Chain, &CertInfo{
+			Subject:            cert.Subject.CommonName,
+			Issuer:             cert.Issuer.CommonName,
+			NotAfter:           cert.NotAfter.UTC().Format(time.RFC3339),
+			DaysLeft:           days,
+			SignatureAlgorithm: cert.SignatureAlgorithm.String(),
+		})
+		// the leaf expiration is already reported by the API check
+		if i > 0 && days < int64(expiryDays) {
+			audit.Notes = append(audit.Notes, fmt.Sprintf("intermediate %s expires in %d days", cert.Subject.CommonName, days))
+		}
+		// a weak signature on a self-signed root isn't meaningful, the signature is never checked
+		if i > 0 && bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+			continue
+		}
+		switch cert.SignatureAlgorithm {
+		case x509.MD2WithRSA, x509.MD5WithRSA, x509.SHA1WithRSA, x509.DSAWithSHA1, x509.ECDSAWithSHA1:
+			audit.WeakSignature = true
+			audit.Notes = append(audit.Notes, fmt.Sprintf("%s signed with %s", cert.Subject.CommonName, cert.SignatureAlgorithm.String()))
+		}
+	}
+
+	audit.KeyType, audit.KeyBits = keyInfo(leaf)
+	switch true {
+	case audit.KeyType == "RSA" && audit.KeyBits < 2048, audit.KeyType == "ECDSA" && audit.KeyBits < 256:
+		audit.WeakKey = true
+		audit.Notes = append(audit.Notes, fmt.Sprintf("weak %d bit %s key", audit.KeyBits, audit.KeyType))
+	}
+
+	audit.HostnameOk = leaf.VerifyHostname(hostname) == nil
+	if !audit.HostnameOk {
+		audit.Notes = append(audit.Notes, "certificate does not match hostname")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	chains, err := leaf.Verify(x509.VerifyOptions{Intermediates: intermediates})
+	if err != nil {
+		audit.VerifyError = err.Error()
+		// browsers will often fetch a missing intermediate, so find out if that's the only problem.
+		var unknownAuthority x509.UnknownAuthorityError
+		if errors.As(err, &unknownAuthority) {
+			if missing := fetchIssuer(state.PeerCertificates[len(state.PeerCertificates)-1]); missing != nil {
+				intermediates.AddCert(missing)
+				chains, err = leaf.Verify(x509.VerifyOptions{Intermediates: intermediates})
+				if err == nil {
+					audit.IncompleteChain = true
+					audit.Notes = append(audit.Notes, "server does not send the full certificate chain")
+				}
+			}
+		}
+		if err != nil {
+			audit.Notes = append(audit.Notes, "certificate chain does not validate")
+		}
+	}
+	audit.ChainOk = err == nil
+
+	var issuer *x509.Certificate
+	if len(chains) > 0 && len(chains[0]) > 1 {
+		issuer = chains[0][1]
+	}
+	audit.OcspStapled = len(state.OCSPResponse) > 0
+	audit.Revocation = revocationStatus(leaf, issuer, state.OCSPResponse)
+	if audit.Revocation == "revoked" {
+		audit.Notes = append(audit.Notes, "certificate has been revoked")
+	}
+	return audit
+}
+
+// Ok is true if there are no findings, used by the report template
+func (ca *CertAudit) Ok() bool {
+	return ca != nil && len(ca.Notes) == 0
+}
+
+func keyInfo(cert *x509.Certificate) (string, int) {
+	switch k := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return "RSA", k.N.BitLen()
+	case *ecdsa.PublicKey:
+		return "ECDSA", k.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return "Ed25519", 256
+	}
+	return cert.PublicKeyAlgorithm.String(), 0
+}
+
+var certFetchClient = &http.Client{Timeout: 5 * time.Second}
+
+// fetchIssuer downloads the issuing certificate using the authority information access extension
+func fetchIssuer(cert *x509.Certificate) *x509.Certificate {
+	for _, u := range cert.IssuingCertificateURL {
+		resp, err := certFetchClient.Get(u)
+		if err != nil {
+			continue
+		}
+		b, err := ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			continue
+		}
+		issuer, err := x509.ParseCertificate(b)
+		if err != nil {
+			continue
+		}
+		return issuer
+	}
+	return nil
+}
+
+// revocationStatus prefers a stapled response, and falls back to asking the OCSP responder. Returns one of:
+// good, revoked, unknown, or unchecked if no answer could be obtained.
+func revocationStatus(leaf *x509.Certificate, issuer *x509.Certificate, stapled []byte) string {
+	if issuer == nil {
+		return "unchecked"
+	}
+	var resp *ocsp.Response
+	var err error
+	if len(stapled) > 0 {
+		resp, err = ocsp.ParseResponseForCert(stapled, leaf, issuer)
+	}
+	if resp == nil && len(leaf.OCSPServer) > 0 {
+		var req []byte
+		req, err = ocsp.CreateRequest(leaf, issuer, nil)
+		if err != nil {
+			return "unchecked"
+		}
+		var httpResp *http.Response
+		httpResp, err = certFetchClient.Post(leaf.OCSPServer[0], "application/ocsp-request", bytes.NewReader(req))
+		if err != nil {
+			return "unchecked"
+		}
+		b, _ := ioutil.ReadAll(httpResp.Body)
+		_ = httpResp.Body.Close()
+		resp, err = ocsp.ParseResponseForCert(b, leaf, issuer)
+	}
+	if err != nil || resp == nil {
+		return "unchecked"
+	}
+	switch resp.Status {
+	case ocsp.Good:
+		return "good"
+	case ocsp.Revoked:
+		return "revoked"
+	}
+	return "unknown"
+}
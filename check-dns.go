@@ -0,0 +1,225 @@
+package fiohealth
+
+import (
+	"errors"
+	"fmt"
+	"github.com/miekg/dns"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	dnsTimeout = 3 * time.Second
+	// shortTtl is noted in the report, very short TTLs make a node sensitive to resolver outages
+	shortTtl = 30
+)
+
+var rcodeStatus = map[int]string{
+	dns.RcodeNameError:     "nxdomain",
+	dns.RcodeServerFailure: "servfail",
+	dns.RcodeRefused:       "refused",
+}
+
+// defaultResolver returns the first nameserver in resolv.conf, used when dns_resolver is not set
+func defaultResolver() string {
+	cc, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+	if err != nil || len(cc.Servers) == 0 {
+		return "8.8.8.8:53"
+	}
+	return net.JoinHostPort(cc.Servers[0], cc.Port)
+}
+
+// dnsQuery sends a single query to server, retrying over TCP if the response is truncated
+func dnsQuery(server string, name string, qtype uint16, recurse bool) (*dns.Msg, time.Duration, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), qtype)
+	m.RecursionDesired = recurse
+	c := &dns.Client{Timeout: dnsTimeout}
+	resp, rtt, err := c.Exchange(m, server)
+	if err == nil && resp.Truncated {
+		c.Net = "tcp"
+		resp, rtt, err = c.Exchange(m, server)
+	}
+	return resp, rtt, err
+}
+
+// DnsCheck looks up the records for a node's hostname using resolver, classifying failures as nxdomain, servfail,
+// refused, or timeout. It records the resolution time and lowest TTL, asks each of the zone's authoritative
+// nameservers for the same records to find any that disagree or are lame, and for TLS hosts looks for a CAA record.
+// Authoritative servers are queried on the same port as the resolver so that tests can use a local stand-in.
+func DnsCheck(host string, tls bool, resolver string) *DnsResult {
+	if host == "" || net.ParseIP(host) != nil {
+		return nil
+	}
+	d := &DnsResult{Host: host, Status: "ok"}
+	answers := make([]dns.RR, 0)
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		resp, rtt, err := dnsQuery(resolver, host, qtype, true)
+		d.ResolveTime += rtt.Milliseconds()
+		if err != nil {
+			d.Status = dnsErrorStatus(err)
+			d.Notes = append(d.Notes, "lookup failed: "+err.Error())
+			return d
+		}
+		if status, ok := rcodeStatus[resp.Rcode]; ok {
+			d.Status = status
+			d.Notes = append(d.Notes, "resolver returned "+dns.RcodeToString[resp.Rcode])
+			return d
+		}
+		answers = append(answers, resp.Answer...)
+	}
+	for i, rr := range answers {
+		if i == 0 || rr.Header().Ttl < d.MinTtl {
+			d.MinTtl = rr.Header().Ttl
+		}
+		switch a := rr.(type) {
+		case *dns.A:
+			d.Addresses = append(d.Addresses, a.A.String())
+		case *dns.AAAA:
+			d.Addresses = append(d.Addresses, a.AAAA.String())
+		}
+	}
+	if len(d.Addresses) == 0 {
+		d.Status = "no_records"
+		d.Notes = append(d.Notes, "no A or AAAA records")
+		return d
+	}
+	sort.Strings(d.Addresses)
+	if d.MinTtl < shortTtl {
+		d.Notes = append(d.Notes, fmt.Sprintf("short TTL of %d seconds", d.MinTtl))
+	}
+
+	d.Nameservers = zoneNameservers(host, resolver)
+	if len(d.Nameservers) == 0 {
+		d.Notes = append(d.Notes, "could not find authoritative nameservers")
+	} else {
+		d.compareNameservers(resolver)
+	}
+
+	if tls {
+		d.Caa = caaRecords(host, resolver)
+		if len(d.Caa) == 0 {
+			// CAA is recommended, but not having one isn't a problem with the node
+			d.Info = append(d.Info, "no CAA record")
+		}
+	}
+	return d
+}
+
+// Ok is true if the name resolved and there are no findings, used by the report template
+func (d *DnsResult) Ok() bool {
+	return d != nil && d.Status == "ok" && len(d.Notes) == 0
+}
+
+func dnsErrorStatus(err error) string {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "timeout"
+	}
+	return "error"
+}
+
+// zoneNameservers finds the NS records for the closest enclosing zone of host
+func zoneNameservers(host string, resolver string) []string {
+	for name := host; strings.Contains(name, "."); name = name[strings.Index(name, ".")+1:] {
+		resp, _, err := dnsQuery(resolver, name, dns.TypeNS, true)
+		if err != nil {
+			return nil
+		}
+		ns := make([]string, 0)
+		for _, rr := range resp.Answer {
+			if n, ok := rr.(*dns.NS); ok && n.Header().Name == dns.Fqdn(name) {
+				ns = append(ns, strings.TrimSuffix(n.Ns, "."))
+			}
+		}
+		if len(ns) > 0 {
+			sort.Strings(ns)
+			return ns
+		}
+	}
+	return nil
+}
+
+// compareNameservers asks each authoritative nameserver directly for the host's records, and notes any that fail
+// to answer, answer without authority, or give a different answer than the others.
+func (d *DnsResult) compareNameservers(resolver string) {
+	_, port, _ := net.SplitHostPort(resolver)
+	answers := make(map[string]string)
+	for _, ns := range d.Nameservers {
+		resp, _, err := dnsQuery(resolver, ns, dns.TypeA, true)
+		if err != nil || len(resp.Answer) == 0 {
+			d.Notes = append(d.Notes, "could not resolve nameserver "+ns)
+			continue
+		}
+		var addr string
+		for _, rr := range resp.Answer {
+			if a, ok := rr.(*dns.A); ok {
+				addr = net.JoinHostPort(a.A.String(), port)
+				break
+			}
+		}
+		if addr == "" {
+			d.Notes = append(d.Notes, "could not resolve nameserver "+ns)
+			continue
+		}
+		records := make([]string, 0)
+		for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			resp, _, err = dnsQuery(addr, d.Host, qtype, false)
+			if err != nil {
+				break
+			}
+			if !resp.Authoritative {
+				err = errors.New("lame delegation")
+				break
+			}
+			for _, rr := range resp.Answer {
+				// the TTL is left out, it counts down on some servers
+				records = append(records, dns.TypeToString[rr.Header().Rrtype]+" "+strings.TrimPrefix(rr.String(), rr.Header().String()))
+			}
+		}
+		if err != nil {
+			d.Notes = append(d.Notes, fmt.Sprintf("nameserver %s: %s", ns, err.Error()))
+			continue
+		}
+		sort.Strings(records)
+		answers[ns] = strings.Join(records, ", ")
+	}
+	var first string
+	for _, ns := range d.Nameservers {
+		answer, ok := answers[ns]
+		if !ok {
+			continue
+		}
+		if first == "" {
+			first = ns
+			continue
+		}
+		if answer != answers[first] {
+			d.NsMismatch = true
+			d.Notes = append(d.Notes, fmt.Sprintf("authoritative nameservers disagree: %s and %s", first, ns))
+			return
+		}
+	}
+}
+
+// caaRecords finds the CAA records that apply to host, searching parent domains as a certificate authority would
+func caaRecords(host string, resolver string) []string {
+	for name := host; strings.Contains(name, "."); name = name[strings.Index(name, ".")+1:] {
+		resp, _, err := dnsQuery(resolver, name, dns.TypeCAA, true)
+		if err != nil {
+			return nil
+		}
+		caa := make([]string, 0)
+		for _, rr := range resp.Answer {
+			if c, ok := rr.(*dns.CAA); ok {
+				caa = append(caa, fmt.Sprintf("%d %s %q", c.Flag, c.Tag, c.Value))
+			}
+		}
+		if len(caa) > 0 {
+			return caa
+		}
+	}
+	return nil
+}
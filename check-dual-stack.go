@@ -0,0 +1,172 @@
+package fiohealth
+
+import (
+	"context"
+	"encoding/hex"
+	"github.com/fioprotocol/fio-go/eos/p2p"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// familyDial returns a DialContext func that only connects using network, "tcp4" or "tcp6", so the hostname is
+// resolved using only A or AAAA records.
+func familyDial(network string) func(ctx context.Context, _ string, addr string) (net.Conn, error) {
+	dialer := &net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	return func(ctx context.Context, _ string, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, addr)
+	}
+}
+
+// Failed is true when a node publishes addresses for a family but could not be reached using them.
+func (f *FamilyResult) Failed() bool {
+	return f != nil && f.Published && !f.Reachable
+}
+
+// ApiFamily checks if an API node responds to get_info using only one address family, ipNet is "ip4" or "ip6"
+func ApiFamily(node string, ipNet string) *FamilyResult {
+	f := &FamilyResult{}
+	u, err := url.Parse(node)
+	if err != nil {
+		f.Error = err.Error()
+		return f
+	}
+	ips, err := ResolveFamily(u.Hostname(), ipNet)
+	if err != nil {
+		f.Error = err.Error()
+		return f
+	}
+	if len(ips) == 0 {
+		return f
+	}
+	f.Published, f.Address = true, ips[0]
+	started := time.Now().UTC()
+	_, err = newApiDialer(node, familyDial("tcp"+ipNet[2:]))
+	if err != nil {
+		f.Error = err.Error()
+		return f
+	}
+	f.Reachable = true
+	f.Latency = time.Now().UTC().Sub(started).Milliseconds()
+	return f
+}
+
+// P2pFamily checks if a peer accepts a connection and sends a handshake using only one address family. The p2p client
+// always dials "tcp", so the family is forced by connecting to the first address of that family.
+func P2pFamily(p2pnode string, ipNet string, conf *Config) *FamilyResult {
+	f := &FamilyResult{}
+	host, port, err := net.SplitHostPort(p2pnode)
+	if err != nil {
+		f.Error = err.Error()
+		return f
+	}
+	ips, err := ResolveFamily(host, ipNet)
+	if err != nil {
+		f.Error = err.Error()
+		return f
+	}
+	if len(ips) == 0 {
+		return f
+	}
+	f.Published, f.Address = true, ips[0]
+	cid, err := hex.DecodeString(conf.ChainId)
+	if err != nil {
+		f.Error = err.Error()
+		return f
+	}
+	peer := p2p.NewOutgoingPeer(dialAddress(host, ips[0], port), "healthcheck", &p2p.HandshakeInfo{
+		ChainID:      cid,
+		HeadBlockNum: 1,
+	})
+	peer.SetConnectionTimeout(10 * time.Second)
+	client := p2p.NewClient(peer, false)
+	client.SetReadTimeout(time.Second)
+
+	started := time.Now().UTC()
+	mux := sync.Mutex{}
+	client.RegisterHandler(p2p.HandlerFunc(func(envelope *p2p.Envelope) {
+		// any message, even a go away, shows the peer is listening on this family
+		mux.Lock()
+		defer mux.Unlock()
+		if !f.Reachable {
+			f.Reachable = true
+			f.Latency = time.Now().UTC().Sub(started).Milliseconds()
+			_ = client.CloseConnection()
+		}
+	}))
+	go func() {
+		time.Sleep(10 * time.Second)
+		_ = client.CloseConnection()
+	}()
+	err = client.Start()
+
+	mux.Lock()
+	defer mux.Unlock()
+	if !f.Reachable && err != nil {
+		var status P2pStatus
+		status, f.Error = ConnectionStatus(err)
+		// a full peer is still reachable
+		f.Reachable = status == P2pPeerLimit
+	}
+	if !f.Reachable && f.Error == "" {
+		f.Error = "no response"
+	}
+	return f
+}
+
+// apiDualStack tests an API node over IPv4 and IPv6 concurrently, and alarms if it has AAAA records that don't work.
+func apiDualStack(node string, conf *Config) (v4 *FamilyResult, v6 *FamilyResult) {
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		v4 = ApiFamily(node, "ip4")
+	}()
+	go func() {
+		defer wg.Done()
+		v6 = ApiFamily(node, "ip6")
+	}()
+	wg.Wait()
+	if v6.Failed() {
+		conf.ApiAlerts.HostFailed(node+" (IPv6)", ipv6Finding(v6), conf.FlapSuppression)
+	} else {
+		conf.ApiAlerts.HealthOk(node + " (IPv6)")
+	}
+	return
+}
+
+// p2pDualStack tests a peer over IPv4 and IPv6 concurrently, and alarms if it has AAAA records that don't work.
+func p2pDualStack(p2pnode string, conf *Config) (v4 *FamilyResult, v6 *FamilyResult) {
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		v4 = P2pFamily(p2pnode, "ip4", conf)
+	}()
+	go func() {
+		defer wg.Done()
+		v6 = P2pFamily(p2pnode, "ip6", conf)
+	}()
+	wg.Wait()
+	if v6.Failed() {
+		conf.P2pAlerts.HostFailed(p2pnode+" (IPv6)", ipv6Finding(v6), conf.FlapSuppression)
+	} else {
+		conf.P2pAlerts.HostOk(p2pnode + " (IPv6)")
+	}
+	return
+}
+
+// ipv6Finding describes a node that publishes AAAA records that don't work
+func ipv6Finding(v6 *FamilyResult) *Finding {
+	return &Finding{
+		Check:    "ipv6",
+		Category: CategoryHealth,
+		Severity: SeverityCritical,
+		Message:  "publishes AAAA records but is unreachable over IPv6: " + v6.Error,
+		Observed: v6.Address,
+	}
+}
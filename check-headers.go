@@ -0,0 +1,156 @@
+package fiohealth
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AuditHeaders evaluates the security related response headers for an API node. The header argument is taken from a
+// normal API response, additional requests are made to check the plain-HTTP redirect and the CORS preflight
+// used by browser based wallets.
+func AuditHeaders(client *http.Client, baseUrl string, header http.Header) []*HeaderFinding {
+	findings := make([]*HeaderFinding, 0)
+	isTls := strings.HasPrefix(baseUrl, "https")
+
+	if isTls {
+		findings = append(findings, checkHsts(header.Get("Strict-Transport-Security")))
+	}
+
+	nosniff := &HeaderFinding{Name: "X-Content-Type-Options", Value: header.Get("X-Content-Type-Options")}
+	nosniff.Ok = strings.ToLower(nosniff.Value) == "nosniff"
+	if !nosniff.Ok {
+		nosniff.Note = "nosniff not set"
+	}
+	findings = append(findings, nosniff)
+
+	// a version number in either of these headers makes it easy to find vulnerable nodes
+	versionRe := regexp.MustCompile(`\d+\.\d+`)
+	server := &HeaderFinding{Name: "Server", Value: header.Get("Server"), Ok: true}
+	if versionRe.MatchString(server.Value) {
+		server.Ok = false
+		server.Note = "server version disclosed"
+	}
+	findings = append(findings, server)
+	if pb := header.Get("X-Powered-By"); pb != "" {
+		findings = append(findings, &HeaderFinding{Name: "X-Powered-By", Value: pb, Note: "implementation disclosed"})
+	}
+
+	// don't follow redirects, and don't wait long, the remaining checks are informational
+	noFollow := &http.Client{
+		Transport: client.Transport,
+		Timeout:   5 * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	if isTls {
+		findings = append(findings, checkRedirect(noFollow, baseUrl))
+	}
+	findings = append(findings, checkPreflight(noFollow, baseUrl)...)
+	return findings
+}
+
+func checkHsts(value string) *HeaderFinding {
+	hsts := &HeaderFinding{Name: "Strict-Transport-Security", Value: value}
+	if value == "" {
+		hsts.Note = "HSTS not set"
+		return hsts
+	}
+	for _, directive := range strings.Split(value, ";") {
+		directive = strings.TrimSpace(strings.ToLower(directive))
+		if strings.HasPrefix(directive, "max-age=") {
+			age, _ := strconv.ParseInt(strings.Trim(strings.TrimPrefix(directive, "max-age="), `"`), 10, 64)
+			if age > 0 {
+				hsts.Ok = true
+				return hsts
+			}
+		}
+	}
+	hsts.Note = "HSTS max-age missing or zero"
+	return hsts
+}
+
+// checkRedirect ensures that plain HTTP requests are sent to the HTTPS site, not serving HTTP at all is also fine.
+func checkRedirect(client *http.Client, baseUrl string) *HeaderFinding {
+	redirect := &HeaderFinding{Name: "HTTP redirect"}
+	plain := "http://" + strings.TrimPrefix(baseUrl, "https://")
+	// only the default port can be tested, a custom https port will not also be serving plain http.
+	if u := strings.SplitN(strings.TrimPrefix(plain, "http://"), "/", 2)[0]; strings.Contains(u, ":") {
+		redirect.Ok = true
+		redirect.Note = "non-standard port, not tested"
+		return redirect
+	}
+	resp, err := client.Get(plain + "/v1/chain/get_info")
+	if err != nil {
+		redirect.Ok = true
+		redirect.Note = "plain http not available"
+		return redirect
+	}
+	_ = resp.Body.Close()
+	redirect.Value = resp.Header.Get("Location")
+	switch resp.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		if strings.HasPrefix(redirect.Value, "https://") {
+			redirect.Ok = true
+			return redirect
+		}
+		redirect.Note = "plain http redirects to an insecure location"
+	default:
+		redirect.Value = strconv.Itoa(resp.StatusCode)
+		redirect.Note = "plain http is served without redirecting"
+	}
+	return redirect
+}
+
+// checkPreflight sends the same OPTIONS request a browser would before POSTing to the API
+func checkPreflight(client *http.Client, baseUrl string) []*HeaderFinding {
+	preflight := &HeaderFinding{Name: "CORS preflight"}
+	methods := &HeaderFinding{Name: "Allowed methods"}
+	req, err := http.NewRequest(http.MethodOptions, baseUrl+"/v1/chain/get_info", nil)
+	if err != nil {
+		return nil
+	}
+	req.Header.Set("Origin", "https://fio.health")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	req.Header.Set("Access-Control-Request-Headers", "content-type")
+	resp, err := client.Do(req)
+	if err != nil {
+		preflight.Note = "OPTIONS request failed"
+		return []*HeaderFinding{preflight}
+	}
+	_ = resp.Body.Close()
+
+	preflight.Value = resp.Header.Get("Access-Control-Allow-Origin")
+	allowMethods := resp.Header.Get("Access-Control-Allow-Methods")
+	switch true {
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		preflight.Note = "OPTIONS returned " + strconv.Itoa(resp.StatusCode)
+	case preflight.Value == "":
+		preflight.Note = "preflight missing Access-Control-Allow-Origin"
+	case allowMethods != "" && allowMethods != "*" && !strings.Contains(strings.ToUpper(allowMethods), http.MethodPost):
+		preflight.Note = "preflight does not allow POST"
+	default:
+		preflight.Ok = true
+	}
+
+	methods.Value = allowMethods
+	if methods.Value == "" {
+		methods.Value = resp.Header.Get("Allow")
+	}
+	methods.Ok = true
+	unsafe := make([]string, 0)
+	for _, m := range strings.Split(strings.ToUpper(methods.Value), ",") {
+		switch strings.TrimSpace(m) {
+		case http.MethodPut, http.MethodDelete, http.MethodPatch, http.MethodTrace, http.MethodConnect:
+			unsafe = append(unsafe, strings.TrimSpace(m))
+		}
+	}
+	if len(unsafe) > 0 {
+		methods.Ok = false
+		methods.Note = "unneeded methods allowed: " + strings.Join(unsafe, ", ")
+	}
+	return []*HeaderFinding{preflight, methods}
+}
@@ -0,0 +1,127 @@
+package fiohealth
+
+import (
+	"fmt"
+	"github.com/fioprotocol/fio-go"
+	"github.com/fioprotocol/fio-go/eos"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CheckHistory runs checks against nodes serving the v1 history API. It looks up the most recent action for a known
+// account, and compares the block time of that action to the head block time of the node, if the newest action is
+// older than the configured threshold the history plugin is likely lagging or has stopped indexing. It also requests
+// the transaction for that action to ensure get_transaction is working.
+func CheckHistory(conf *Config) (report []*HistoryResult) {
+	if len(conf.HistoryNodes) == 0 {
+		return nil
+	}
+	geo := conf.TestOrigin()
+	results := make([]*HistoryResult, len(conf.HistoryNodes))
+	wg := sync.WaitGroup{}
+	wg.Add(len(conf.HistoryNodes))
+	for i := range conf.HistoryNodes {
+		go func(i int) {
+			defer wg.Done()
+			results[i] = HistoryConnect(conf.HistoryNodes[i], geo, conf)
+			// alarms are tracked separately from the API check, a node may be listed as both.
+			alarmKey := conf.HistoryNodes[i] + "/v1/history"
+			if results[i].HadError {
+				for _, f := range results[i].Findings {
+					conf.ApiAlerts.HostFailed(alarmKey, f, conf.FlapSuppression)
+				}
+			} else {
+				conf.ApiAlerts.HealthOk(alarmKey)
+			}
+		}(i)
+	}
+	wg.Wait()
+	conf.Log("all history nodes tested, history check completed")
+	return results
+}
+
+// HistoryConnect tests a single history node
+func HistoryConnect(node string, geo string, conf *Config) *HistoryResult {
+	r := &HistoryResult{
+		Type:      "history",
+		Node:      node,
+		TimeStamp: time.Now().UTC().Unix(),
+		FromGeo:   geo,
+	}
+	rules := conf.Rules(node)
+	failed := func(why string, errFor string) *HistoryResult {
+		log.Println(node, errFor, why)
+		r.HadError = true
+		r.Error = why
+		r.ErrorFor = errFor
+		r.Add(&Finding{
+			Check:    strings.ReplaceAll(errFor, " ", "_"),
+			Category: CategoryHealth,
+			Severity: SeverityCritical,
+			Message:  errFor + ": " + why,
+		}, rules.History.Connection)
+		return r
+	}
+
+	api, _, err := fio.NewConnection(nil, node)
+	if err != nil {
+		emsg := err.Error()
+		switch true {
+		case strings.HasSuffix(emsg, "timeout"):
+			emsg = "connection timeout"
+		case strings.HasSuffix(emsg, "no such host"):
+			emsg = "name lookup failed"
+		case strings.HasSuffix(emsg, "connection reset by peer"):
+			emsg = "connection refused"
+		}
+		return failed(emsg, "initial connection")
+	}
+	gi, err := api.GetInfo()
+	if err != nil {
+		return failed(err.Error(), "get info")
+	}
+	r.HeadBlock = gi.HeadBlockNum
+
+	before := time.Now().UTC()
+	actions, err := api.GetActions(eos.GetActionsRequest{
+		AccountName: eos.AccountName(conf.HistoryAccount),
+		Pos:         -1,
+		Offset:      -1,
+	})
+	r.RequestLatency = time.Now().UTC().Sub(before).Milliseconds()
+	r.Slow = r.RequestLatency > rules.SlowResponse
+	if err != nil {
+		return failed(err.Error(), "get actions")
+	}
+	if actions == nil || len(actions.Actions) == 0 {
+		return failed("no actions found for "+conf.HistoryAccount, "get actions")
+	}
+	latest := actions.Actions[len(actions.Actions)-1]
+	r.LastActionBlock = latest.BlockNum
+	r.HistoryLag = gi.HeadBlockTime.Time.Sub(latest.BlockTime.Time).Milliseconds()
+	if r.HistoryLag > int64(conf.HistoryMaxLag)*1000 {
+		r.HadError = true
+		r.Error = fmt.Sprintf("newest action for %s is %.0f seconds behind head", conf.HistoryAccount, float64(r.HistoryLag)/1000)
+		r.ErrorFor = "get actions"
+		r.Add(&Finding{
+			Check:     "history_lag",
+			Category:  CategoryHealth,
+			Severity:  SeverityWarning,
+			Message:   r.Error,
+			Observed:  fmt.Sprintf("%.0f s", float64(r.HistoryLag)/1000),
+			Threshold: fmt.Sprintf("%d s", conf.HistoryMaxLag),
+		}, rules.History.Lagging)
+	}
+
+	trx, err := api.GetTransaction(latest.Trace.TransactionID)
+	if err != nil {
+		return failed(err.Error(), "get transaction")
+	}
+	if trx.BlockNum != latest.BlockNum {
+		return failed("get_transaction did not return the expected transaction", "get transaction")
+	}
+	r.TransactionOk = true
+	return r
+}
@@ -0,0 +1,96 @@
+package fiohealth
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/fioprotocol/fio-go/eos"
+	"github.com/fioprotocol/fio-go/eos/p2p"
+	"sync"
+	"time"
+)
+
+// maxSyncTime limits how long the catch-up test can run for a single peer
+const maxSyncTime = 20 * time.Second
+
+// P2pSync tests if a new node could sync from a peer. It reconnects claiming a head block that is behind the peer's
+// last irreversible block, and uses the catch-up mode of the p2p client to request blocks in batches, measuring how
+// quickly the requested range is served. The requested range ends before the peer's LIB so that the blocks should
+// come from the block log.
+func P2pSync(p2pnode string, lib uint32, conf *Config) *SyncResult {
+	requested := uint32(conf.P2pSyncBlocks)
+	sr := &SyncResult{Requested: conf.P2pSyncBlocks}
+	if lib <= requested+1 {
+		sr.Error = "peer LIB is too low to test syncing"
+		return sr
+	}
+	sr.StartBlock = lib - requested - 1
+	cid, err := hex.DecodeString(conf.ChainId)
+	if err != nil {
+		sr.Error = err.Error()
+		return sr
+	}
+	peer := p2p.NewOutgoingPeer(p2pnode, "healthcheck", &p2p.HandshakeInfo{
+		ChainID:      cid,
+		HeadBlockNum: sr.StartBlock - 1,
+	})
+	peer.SetConnectionTimeout(10 * time.Second)
+	client := p2p.NewClient(peer, true)
+
+	var first, last time.Time
+	seen := make(map[uint32]bool)
+	mux := sync.Mutex{}
+	client.RegisterHandler(p2p.HandlerFunc(func(envelope *p2p.Envelope) {
+		name, _ := envelope.Packet.Type.Name()
+		switch name {
+		case "GoAway":
+			why := &eos.GoAwayMessage{}
+			if err := eos.UnmarshalBinary(envelope.Packet.Payload, why); err == nil {
+				mux.Lock()
+				sr.Error = "go away: " + why.Reason.String()
+				mux.Unlock()
+			}
+		case "SignedBlock":
+			block := &eos.SignedBlock{}
+			if err := eos.UnmarshalBinary(envelope.Packet.Payload, block); err != nil {
+				return
+			}
+			num := block.BlockNumber()
+			if num < sr.StartBlock || num >= sr.StartBlock+requested {
+				return
+			}
+			mux.Lock()
+			defer mux.Unlock()
+			if seen[num] {
+				return
+			}
+			seen[num] = true
+			now := time.Now().UTC()
+			if first.IsZero() {
+				first = now
+			}
+			last = now
+			if len(seen) == int(requested) {
+				_ = client.CloseConnection()
+			}
+		}
+	}))
+	go func() {
+		time.Sleep(maxSyncTime)
+		_ = client.CloseConnection()
+	}()
+	started := time.Now().UTC()
+	_ = client.Start()
+
+	mux.Lock()
+	defer mux.Unlock()
+	sr.Received = len(seen)
+	sr.Complete = sr.Received == sr.Requested
+	sr.Took = time.Now().UTC().Sub(started).Milliseconds()
+	if elapsed := last.Sub(first).Seconds(); elapsed > 0 {
+		sr.BlocksPerSec = float64(sr.Received-1) / elapsed
+	}
+	if !sr.Complete && sr.Error == "" {
+		sr.Error = fmt.Sprintf("received %d of %d requested blocks", sr.Received, sr.Requested)
+	}
+	return sr
+}
@@ -2,45 +2,114 @@ package fiohealth
 
 import (
 	"encoding/hex"
+	"fmt"
 	"github.com/fioprotocol/fio-go/eos"
 	"github.com/fioprotocol/fio-go/eos/p2p"
 	"log"
-	"strings"
+	"math"
+	"net"
 	"sync"
 	"time"
 )
 
 func CheckP2p(conf *Config) (report []*P2pResult) {
-	geo, err := MyGeo(conf.Geolite)
-	if err != nil {
-		log.Println(err)
-	}
+	geo := conf.TestOrigin()
 	results := make([]*P2pResult, len(conf.P2pNodes))
 	wg := sync.WaitGroup{}
 	wg.Add(len(conf.P2pNodes))
 	for i := range conf.P2pNodes {
 		go func(i int) {
-			results[i] = P2pConnect(conf.P2pNodes[i], geo, conf)
-			if !results[i].Healthy {
-				conf.P2pAlerts.HostFailed(conf.P2pNodes[i], results[i].ErrMsg, conf.FlapSuppression)
-			} else {
-				conf.P2pAlerts.HostOk(conf.P2pNodes[i])
+			defer wg.Done()
+			var v4, v6 *FamilyResult
+			family := sync.WaitGroup{}
+			if conf.DualStack {
+				family.Add(1)
+				go func() {
+					defer family.Done()
+					v4, v6 = p2pDualStack(conf.P2pNodes[i], conf)
+				}()
+			}
+			var dnsResult *DnsResult
+			if host, _, err := net.SplitHostPort(conf.P2pNodes[i]); err == nil && conf.DnsCheck {
+				family.Add(1)
+				go func() {
+					defer family.Done()
+					dnsResult = DnsCheck(host, false, conf.DnsResolver)
+				}()
+			}
+			results[i] = p2pCheck(conf.P2pNodes[i], "", geo, conf)
+			if conf.ResolveAll {
+				results[i].Addresses = p2pAddresses(conf.P2pNodes[i], geo, conf)
+			}
+			family.Wait()
+			results[i].Ipv4, results[i].Ipv6 = v4, v6
+			results[i].Dns = dnsResult
+			if results[i].Status == P2pNameLookup && dnsResult != nil && dnsResult.Status != "ok" {
+				results[i].ErrMsg += " (" + dnsResult.Status + ")"
 			}
-			wg.Done()
 		}(i)
 	}
 	wg.Wait()
 	return results
 }
 
-func P2pConnect(p2pnode string, geo string, conf *Config) *P2pResult {
+// p2pCheck connects to a peer and updates the alarm state
+func p2pCheck(p2pnode string, ip string, geo string, conf *Config) *P2pResult {
+	r := P2pConnect(p2pnode, ip, geo, conf)
+	// alarms for individual addresses are tracked separately from the hostname
+	alarmKey := p2pnode
+	if ip != "" {
+		alarmKey = p2pnode + " (" + ip + ")"
+	}
+	if !r.Healthy && r.Status.Alarm() {
+		for _, f := range r.Findings {
+			if f.Severity != SeverityInfo {
+				conf.P2pAlerts.HostFailed(alarmKey, f, conf.FlapSuppression)
+			}
+		}
+	} else {
+		conf.P2pAlerts.HostOk(alarmKey)
+	}
+	return r
+}
+
+// p2pAddresses tests each address for a hostname, if it only has one address there is nothing to add.
+func p2pAddresses(p2pnode string, geo string, conf *Config) []*P2pResult {
+	host, _, err := net.SplitHostPort(p2pnode)
+	if err != nil {
+		return nil
+	}
+	ips, _ := ResolveAll(host)
+	if len(ips) < 2 {
+		return nil
+	}
+	results := make([]*P2pResult, len(ips))
+	wg := sync.WaitGroup{}
+	wg.Add(len(ips))
+	for i := range ips {
+		go func(i int) {
+			defer wg.Done()
+			results[i] = p2pCheck(p2pnode, ips[i], geo, conf)
+		}(i)
+	}
+	wg.Wait()
+	return results
+}
+
+// P2pConnect tests a peer, if ip is not empty the connection is made to that address instead of resolving the host.
+func P2pConnect(p2pnode string, ip string, geo string, conf *Config) *P2pResult {
 	started := time.Now().UTC()
-	r := P2pResult{Type: "p2p", Peer: p2pnode, FromGeo: geo, TimeStamp: time.Now().UTC().Unix()}
+	r := P2pResult{Type: "p2p", Peer: p2pnode, Ip: ip, FromGeo: geo, TimeStamp: time.Now().UTC().Unix()}
+	rules := conf.Rules(p2pnode)
+	dial := p2pnode
+	if host, port, err := net.SplitHostPort(p2pnode); err == nil {
+		dial = dialAddress(host, ip, port)
+	}
 	cid, err := hex.DecodeString(conf.ChainId)
 	if err != nil {
 		log.Fatal(err)
 	}
-	peer := p2p.NewOutgoingPeer(p2pnode, "healthcheck", &p2p.HandshakeInfo{
+	peer := p2p.NewOutgoingPeer(dial, "healthcheck", &p2p.HandshakeInfo{
 		ChainID:      cid,
 		HeadBlockNum: 1,
 	})
@@ -50,6 +119,10 @@ func P2pConnect(p2pnode string, geo string, conf *Config) *P2pResult {
 		false,
 	)
 	client.SetReadTimeout(time.Second)
+	var stream *blockStream
+	if conf.P2pObserve > 0 {
+		stream = &blockStream{seen: make(map[uint32]bool)}
+	}
 	blockHandler := p2p.HandlerFunc(func(envelope *p2p.Envelope) {
 		r.Reachable = true
 		name, _ := envelope.Packet.Type.Name()
@@ -61,8 +134,41 @@ func P2pConnect(p2pnode string, geo string, conf *Config) *P2pResult {
 				log.Println(err)
 				r.ErrMsg = err.Error()
 			}
-			r.Score += 1
+			r.Status = GoAwayStatus(why.Reason)
 			r.ErrMsg = why.String() + " " + why.Reason.String()
+			r.Add(&Finding{
+				Check:    "go_away",
+				Category: CategoryHealth,
+				Severity: SeverityCritical,
+				Message:  "go away: " + why.Reason.String(),
+				Observed: string(r.Status),
+			}, rules.P2p.Connection)
+		case "Handshake":
+			hs := &eos.HandshakeMessage{}
+			err := eos.UnmarshalBinary(envelope.Packet.Payload, hs)
+			if err != nil {
+				log.Println(p2pnode, "handshake", err)
+				return
+			}
+			r.Handshake = &PeerHandshake{
+				NodeId:         hs.NodeID.String(),
+				P2pAddress:     hs.P2PAddress,
+				Agent:          hs.Agent,
+				Os:             hs.OS,
+				NetworkVersion: hs.NetworkVersion,
+				ChainId:        hs.ChainID.String(),
+				HeadNum:        hs.HeadNum,
+				HeadId:         hs.HeadID.String(),
+				LibNum:         hs.LastIrreversibleBlockNum,
+				LibId:          hs.LastIrreversibleBlockID.String(),
+			}
+			if r.Handshake.ChainId != conf.ChainId {
+				r.Handshake.WrongChain = true
+			}
+			// with 21 producers LIB normally trails head by a little over 300 blocks
+			if hs.HeadNum > hs.LastIrreversibleBlockNum+rules.MaxLibGap {
+				r.Handshake.StaleLib = true
+			}
 		case "SignedBlock":
 			block := &eos.SignedBlock{}
 			err := eos.UnmarshalBinary(envelope.Packet.Payload, block)
@@ -70,44 +176,192 @@ func P2pConnect(p2pnode string, geo string, conf *Config) *P2pResult {
 				r.ErrMsg = err.Error()
 				return
 			}
+			if stream != nil {
+				stream.add(block.BlockNumber(), time.Now().UTC())
+				// only the first block is used for latency, the rest of the window is used for the stream stats
+				if stream.blocks > 1 {
+					return
+				}
+			}
 			delta := time.Now().UTC().Sub(block.Timestamp.Time)
-			if delta.Seconds() < 30 {
+			if delta.Seconds() < float64(rules.HeadBlockLag) {
 				r.Healthy = true
+				r.Status = P2pOk
 			} else {
-				r.Score += 1
+				r.Status = P2pLagging
+				r.ErrMsg = fmt.Sprintf("head block is behind by %.2f", delta.Seconds())
+				r.Add(&Finding{
+					Check:     "head_block_lag",
+					Category:  CategoryHealth,
+					Severity:  SeverityWarning,
+					Message:   r.ErrMsg,
+					Observed:  fmt.Sprintf("%.2f s", delta.Seconds()),
+					Threshold: fmt.Sprintf("%d s", rules.HeadBlockLag),
+				}, rules.P2p.Lagging)
 			}
 			r.HeadBlockLatency = delta.Milliseconds()
-			_ = client.CloseConnection()
+			if stream == nil {
+				_ = client.CloseConnection()
+			}
 		}
 	})
 	client.RegisterHandler(blockHandler)
 	go func() {
-		time.Sleep(2 * time.Second)
+		// allow a couple of seconds for the handshake before the observation window starts
+		time.Sleep(2*time.Second + time.Duration(conf.P2pObserve)*time.Second)
 		_ = client.CloseConnection()
 	}()
 	err = client.Start()
-	if err != nil {
-		if !r.Healthy {
-			emsg := err.Error()
-			switch true {
-			case strings.HasSuffix(emsg, "err: EOF"):
-				emsg = "too many peers? (EOF)"
-				r.Reachable = true
-			case strings.HasSuffix(emsg, "use of closed network connection"):
-				emsg = "too slow to respond"
-			case strings.HasSuffix(emsg, "timeout"):
-				emsg = "connection timeout"
-			case strings.HasSuffix(emsg, "no such host"):
-				emsg = "name lookup failed"
-			case strings.HasSuffix(emsg, "connection reset by peer"):
-				emsg = "connection reset"
-			case strings.HasSuffix(emsg, "connection refused"):
-				emsg = "connection refused"
-			}
-			r.ErrMsg = emsg
-			r.Score += 1
+	// a go away has already been classified, the error that follows is only the connection closing
+	if err != nil && !r.Healthy && r.Status == "" {
+		r.Status, r.ErrMsg = ConnectionStatus(err)
+		if r.Status == P2pPeerLimit {
+			r.Reachable = true
 		}
+		r.Add(&Finding{Check: "connection", Category: CategoryHealth, Severity: SeverityCritical, Message: r.ErrMsg, Observed: string(r.Status)}, rules.P2p.Connection)
 	}
-	r.Took = time.Now().UTC().Sub(started).Milliseconds() / 1000
+	if r.Status == "" {
+		r.Status = P2pNoBlocks
+		r.ErrMsg = "no blocks received"
+		r.Add(&Finding{Check: "no_blocks", Category: CategoryHealth, Severity: SeverityCritical, Message: r.ErrMsg}, rules.P2p.Connection)
+	}
+	if stream != nil && r.Healthy {
+		r.Stream = stream.result(conf.P2pObserve)
+		switch true {
+		case r.Stream.Missing > 0:
+			r.Healthy = false
+			r.Status = P2pStreamDegraded
+			r.ErrMsg = fmt.Sprintf("%d blocks missing from stream", r.Stream.Missing)
+			r.Add(&Finding{
+				Check:     "stream_missing",
+				Category:  CategoryHealth,
+				Severity:  SeverityWarning,
+				Message:   r.ErrMsg,
+				Observed:  fmt.Sprintf("%d blocks", r.Stream.Missing),
+				Threshold: "0 blocks",
+			}, rules.P2p.Stream)
+		case r.Stream.MaxGap > rules.MaxStreamGap:
+			r.Healthy = false
+			r.Status = P2pStreamDegraded
+			r.ErrMsg = fmt.Sprintf("block stream stalled for %d ms", r.Stream.MaxGap)
+			r.Add(&Finding{
+				Check:     "stream_gap",
+				Category:  CategoryHealth,
+				Severity:  SeverityWarning,
+				Message:   r.ErrMsg,
+				Observed:  fmt.Sprintf("%d ms", r.Stream.MaxGap),
+				Threshold: fmt.Sprintf("%d ms", rules.MaxStreamGap),
+			}, rules.P2p.Stream)
+		}
+	}
+	if r.Handshake != nil {
+		switch true {
+		case r.Handshake.WrongChain:
+			r.Healthy = false
+			r.Status = P2pWrongChain
+			r.ErrMsg = "wrong chain id in handshake"
+			r.Add(&Finding{
+				Check:     "chain_id",
+				Category:  CategoryHealth,
+				Severity:  SeverityCritical,
+				Message:   r.ErrMsg,
+				Observed:  r.Handshake.ChainId,
+				Threshold: conf.ChainId,
+			}, rules.P2p.WrongChain)
+		case r.Handshake.StaleLib:
+			r.Healthy = false
+			r.Status = P2pStaleLib
+			r.ErrMsg = fmt.Sprintf("LIB is %d blocks behind head", r.Handshake.HeadNum-r.Handshake.LibNum)
+			r.Add(&Finding{
+				Check:     "lib_gap",
+				Category:  CategoryHealth,
+				Severity:  SeverityWarning,
+				Message:   r.ErrMsg,
+				Observed:  fmt.Sprintf("%d blocks", r.Handshake.HeadNum-r.Handshake.LibNum),
+				Threshold: fmt.Sprintf("%d blocks", rules.MaxLibGap),
+			}, rules.P2p.Lagging)
+		}
+	}
+	if conf.P2pSyncBlocks > 0 && r.Handshake != nil && !r.Handshake.WrongChain {
+		r.Sync = P2pSync(dial, r.Handshake.LibNum, conf)
+		if !r.Sync.Complete {
+			r.Add(&Finding{Check: "sync", Category: CategoryHealth, Severity: SeverityWarning, Message: r.Sync.Error}, rules.P2p.Sync)
+		}
+	}
+	r.TookMs = time.Now().UTC().Sub(started).Milliseconds()
+	r.Took = r.TookMs / 1000
 	return &r
 }
+
+// blockStream tracks block arrival during the observation window. Missing blocks are the numbers between the lowest
+// and highest seen that never arrived, so blocks received out of order are not counted.
+type blockStream struct {
+	blocks     int
+	duplicates int
+	first      time.Time
+	last       time.Time
+	lowest     uint32
+	highest    uint32
+	deltas     []float64
+	seen       map[uint32]bool
+}
+
+func (bs *blockStream) add(num uint32, arrived time.Time) {
+	bs.blocks += 1
+	if bs.seen[num] {
+		bs.duplicates += 1
+		return
+	}
+	bs.seen[num] = true
+	if bs.blocks == 1 {
+		bs.first = arrived
+		bs.lowest, bs.highest = num, num
+	} else {
+		bs.deltas = append(bs.deltas, float64(arrived.Sub(bs.last).Milliseconds()))
+	}
+	bs.last = arrived
+	if num < bs.lowest {
+		bs.lowest = num
+	}
+	if num > bs.highest {
+		bs.highest = num
+	}
+}
+
+// missing counts the gaps in the range of block numbers seen
+func (bs *blockStream) missing() int {
+	if len(bs.seen) == 0 {
+		return 0
+	}
+	return int(bs.highest-bs.lowest+1) - len(bs.seen)
+}
+
+func (bs *blockStream) result(window int) *BlockStream {
+	stream := &BlockStream{
+		Window:     window,
+		Blocks:     bs.blocks,
+		Duplicates: bs.duplicates,
+		Missing:    bs.missing(),
+	}
+	if elapsed := bs.last.Sub(bs.first).Seconds(); elapsed > 0 {
+		stream.BlocksPerSec = float64(len(bs.deltas)) / elapsed
+	}
+	if len(bs.deltas) == 0 {
+		return stream
+	}
+	var sum, max float64
+	for _, d := range bs.deltas {
+		sum += d
+		if d > max {
+			max = d
+		}
+	}
+	mean := sum / float64(len(bs.deltas))
+	var variance float64
+	for _, d := range bs.deltas {
+		variance += (d - mean) * (d - mean)
+	}
+	stream.Jitter = math.Sqrt(variance / float64(len(bs.deltas)))
+	stream.MaxGap = int64(max)
+	return stream
+}
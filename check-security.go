@@ -0,0 +1,73 @@
+package fiohealth
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// sensitiveEndpoint describes a nodeos API that should never be reachable on a public API node.
+type sensitiveEndpoint struct {
+	Name     string
+	Endpoint string
+	Method   string
+}
+
+// malformedBody is sent with every POST probe. nodeos treats an empty body as "{}", so endpoints that take parameters
+// would really be called, but a body that can't be parsed is rejected before the call is made. Endpoints without
+// parameters ignore the body, only read-only ones of those are listed.
+const malformedBody = `{"fio-health":`
+
+// sensitiveEndpoints is the list of probes run against every API node. The producer API's create_snapshot (and
+// pause, resume, etc.) ignores the body and can't be probed safely, it is enabled whenever "producer api" is exposed.
+var sensitiveEndpoints = []sensitiveEndpoint{
+	{Name: "net api", Endpoint: "/v1/net/connections", Method: http.MethodPost},
+	{Name: "net connect", Endpoint: "/v1/net/connect", Method: http.MethodPost},
+	{Name: "producer api", Endpoint: "/v1/producer/paused", Method: http.MethodPost},
+	{Name: "producer integrity hash", Endpoint: "/v1/producer/get_integrity_hash", Method: http.MethodPost},
+	{Name: "db size api", Endpoint: "/v1/db_size/get", Method: http.MethodGet},
+	{Name: "wallet api", Endpoint: "/v1/wallet/list_wallets", Method: http.MethodPost},
+	{Name: "test control api", Endpoint: "/v1/test_control/kill_node_on_producer", Method: http.MethodPost},
+	{Name: "trace api", Endpoint: "/v1/trace_api/get_block", Method: http.MethodPost},
+}
+
+// ProbeEndpoints requests each of the sensitive endpoints, and returns a finding for each. Any transport error is
+// treated as not exposed.
+func ProbeEndpoints(client *http.Client, baseUrl string) []*SecurityFinding {
+	findings := make([]*SecurityFinding, 0)
+	for _, se := range sensitiveEndpoints {
+		finding := &SecurityFinding{Name: se.Name, Endpoint: se.Endpoint}
+		findings = append(findings, finding)
+		var body io.Reader
+		if se.Method == http.MethodPost {
+			body = strings.NewReader(malformedBody)
+		}
+		req, err := http.NewRequest(se.Method, baseUrl+se.Endpoint, body)
+		if err != nil {
+			continue
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			continue
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		finding.Status = resp.StatusCode
+		finding.Exposed = isExposed(resp.StatusCode, b)
+	}
+	return findings
+}
+
+// isExposed decides if a response came from an enabled plugin. nodeos returns a 404 for unknown endpoints, but if
+// the plugin is loaded the malformed body is rejected with a parse error, a 400 (or 500 on older versions).
+func isExposed(status int, body []byte) bool {
+	switch true {
+	case status >= 200 && status < 300:
+		return true
+	case status == http.StatusBadRequest, status == http.StatusInternalServerError:
+		b := string(body)
+		return strings.Contains(b, `"error"`) && !strings.Contains(b, "Unknown Endpoint")
+	}
+	return false
+}
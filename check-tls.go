@@ -1,6 +1,7 @@
 package fiohealth
 
 import (
+	ctls "crypto/tls"
 	tls "github.com/refraction-networking/utls"
 	"log"
 	"net"
@@ -9,125 +10,234 @@ import (
 	"time"
 )
 
-// TestTls looks for old TLS versions and weak cipher suites, this is not the standard Go tls library, and it does
-// not attempt to validate the certificate, knowing the settings are weak outside of having an invalid cert are
-// useful findings.
-func TestTls(uri string, debug bool) (string, bool) {
+// ScanTls builds a matrix of the TLS versions, cipher suites, key exchange groups, and ALPN protocols supported by
+// a server. This is not the standard Go tls library, and it does not attempt to validate the certificate, knowing
+// the settings are weak outside of having an invalid cert are useful findings. Only the server hello is needed to
+// know a setting is supported, so the handshake is not required to complete. If ip is set connections are made to
+// that address instead of resolving the hostname.
+func ScanTls(uri string, ip string, debug bool) *TlsMatrix {
 	var start time.Time
 	if debug {
 		start = time.Now()
 	}
 	defer func() {
 		if debug && time.Now().Sub(start).Seconds() > 30 {
-			log.Printf("TLS checks for %s took %d seconds", uri, time.Now().Sub(start).Seconds())
+			log.Printf("TLS checks for %s took %.0f seconds", uri, time.Now().Sub(start).Seconds())
 		}
 	}()
 	if !strings.HasPrefix(uri, "https") {
-		return "", false
+		return nil
 	}
 	parsed, _ := url.Parse(uri)
 	port := parsed.Port()
 	if port == "" {
 		port = "443"
 	}
-	hostname := parsed.Host
-	addr := parsed.Host + ":" + port
-	check := func(versions []uint16, suites []uint16) string {
-		for _, vers := range versions {
-			config := tls.Config{
-				ServerName:         hostname,
-				InsecureSkipVerify: true,
-			}
-			dialConn, err := net.DialTimeout("tcp", addr, 2*time.Second)
-			if err != nil {
-				log.Printf("net.DialTimeout error: %+v", err)
-				return ""
-			}
-			uTlsConn := tls.UClient(dialConn, &config, tls.HelloCustom)
+	hostname := parsed.Hostname()
+	addr := dialAddress(hostname, ip, port)
+	matrix := &TlsMatrix{
+		Versions: make([]*TlsVersionSupport, 0),
+		Groups:   make([]string, 0),
+		Alpn:     make([]string, 0),
+	}
 
-			min := vers
-			if min == tls.VersionTLS13 {
-				// 1.3 can't be lowest, not allowed by utls lib.
-				min = tls.VersionTLS12
-			}
-			// most of this taken from the utls example:
-			spec := tls.ClientHelloSpec{
-				TLSVersMax:   vers,
-				TLSVersMin:   min,
-				CipherSuites: suites,
-				Extensions: []tls.TLSExtension{
-					&tls.SNIExtension{},
-					&tls.SupportedCurvesExtension{Curves: []tls.CurveID{tls.X25519, tls.CurveP256}},
-					&tls.SupportedPointsExtension{SupportedPoints: []byte{0}}, // uncompressed
-					&tls.SessionTicketExtension{},
-					&tls.ALPNExtension{AlpnProtocols: []string{"myFancyProtocol", "http/1.1"}},
-					&tls.SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: []tls.SignatureScheme{
-						tls.ECDSAWithSHA1,
-						tls.PKCS1WithSHA1}},
-					&tls.KeyShareExtension{KeyShares: []tls.KeyShare{
-						{Group: tls.CurveID(tls.GREASE_PLACEHOLDER), Data: []byte{0}},
-						{Group: tls.X25519},
-					}},
-					&tls.PSKKeyExchangeModesExtension{Modes: []uint8{1}}, // pskModeDHE
-					&tls.SupportedVersionsExtension{Versions: []uint16{vers}},
-				},
-				GetSessionID: nil,
-			}
-			err = uTlsConn.ApplyPreset(&spec)
-			if err != nil {
-				continue
+	var highest uint16
+	// for each version keep offering the suites that have not been picked yet, until the server refuses.
+	for _, vers := range anyVersion {
+		support := &TlsVersionSupport{
+			Version:    versionNames[vers],
+			Suites:     make([]string, 0),
+			WeakSuites: make([]string, 0),
+		}
+		matrix.Versions = append(matrix.Versions, support)
+		remaining := suitesFor(vers)
+		for len(remaining) > 0 {
+			sh := hello(addr, hostname, vers, remaining, allGroups, nil)
+			if sh == nil || sh.version != vers {
+				break
 			}
-			_ = uTlsConn.SetDeadline(time.Now().Add(2 * time.Second))
-			_ = uTlsConn.Handshake()
-			var result string
-			if uTlsConn.HandshakeState.ServerHello != nil {
-				switch uTlsConn.ConnectionState().Version {
-				case tls.VersionTLS10:
-					result = "TLS v1.0"
-				case tls.VersionTLS11:
-					result = "TLS v1.1"
-					//case tls.VersionTLS12:
-					//	result = "TLS 1.2"
-					//case tls.VersionTLS13:
-					//	result = "TLS 1.3"
-				}
-				switch uTlsConn.ConnectionState().CipherSuite {
-				case tls.TLS_RSA_WITH_RC4_128_SHA:
-					result += " TLS_RSA_WITH_RC4_128_SHA"
-				case tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA:
-					result += " TLS_RSA_WITH_3DES_EDE_CBC_SHA"
-				case tls.TLS_ECDHE_ECDSA_WITH_RC4_128_SHA:
-					result += " TLS_ECDHE_ECDSA_WITH_RC4_128_SHA"
-				case tls.TLS_ECDHE_RSA_WITH_RC4_128_SHA:
-					result += " TLS_ECDHE_RSA_WITH_RC4_128_SHA"
-				case tls.TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA:
-					result += " TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA"
+			support.Supported = true
+			support.Suites = append(support.Suites, ctls.CipherSuiteName(sh.suite))
+			for _, weak := range weakSuites {
+				if weak == sh.suite {
+					support.WeakSuites = append(support.WeakSuites, ctls.CipherSuiteName(sh.suite))
 				}
-				if result == "" {
-					continue
+			}
+			next := make([]uint16, 0)
+			for _, suite := range remaining {
+				if suite != sh.suite {
+					next = append(next, suite)
 				}
-				_ = uTlsConn.Close()
-				return result
 			}
-			_ = uTlsConn.Close()
+			if len(next) == len(remaining) {
+				// server picked something we didn't offer
+				break
+			}
+			remaining = next
+		}
+		if !support.Supported {
+			continue
+		}
+		if vers > highest {
+			highest = vers
+		}
+	}
+	if highest == 0 {
+		return matrix
+	}
+
+	// remaining checks use the best version the server supports. Only ECDHE suites are offered when probing groups,
+	// otherwise the server can pick an RSA key exchange that doesn't use the group at all.
+	for _, group := range allGroups {
+		if sh := hello(addr, hostname, highest, ecdheSuitesFor(highest), []tls.CurveID{group}, nil); sh != nil && sh.version == highest {
+			matrix.Groups = append(matrix.Groups, groupNames[group])
+		}
+	}
+	for _, proto := range []string{"h2", "http/1.1"} {
+		if sh := hello(addr, hostname, highest, suitesFor(highest), allGroups, []string{proto}); sh != nil && sh.version == highest && sh.alpn == proto {
+			matrix.Alpn = append(matrix.Alpn, proto)
+		}
+	}
+	return matrix
+}
+
+// Weaknesses gives a description of each deprecated version or weak suite found in the matrix
+func (m *TlsMatrix) Weaknesses() []string {
+	weaknesses := make([]string, 0)
+	if m == nil {
+		return weaknesses
+	}
+	old := make(map[string]bool)
+	for _, vers := range oldVersion {
+		old[versionNames[vers]] = true
+	}
+	weak := make(map[string]bool)
+	for _, v := range m.Versions {
+		if v.Supported && old[v.Version] {
+			weaknesses = append(weaknesses, v.Version)
+		}
+		for _, suite := range v.WeakSuites {
+			if !weak[suite] {
+				weak[suite] = true
+				weaknesses = append(weaknesses, suite)
+			}
+		}
+	}
+	return weaknesses
+}
+
+// Weak is true if the suite was flagged as weak for this version, used by the node page template
+func (v *TlsVersionSupport) Weak(suite string) bool {
+	for _, w := range v.WeakSuites {
+		if w == suite {
+			return true
 		}
-		return ""
 	}
+	return false
+}
+
+type serverHello struct {
+	version uint16
+	suite   uint16
+	alpn    string
+}
+
+// hello sends a client hello limited to a single version, and returns what the server chose, or nil if the server
+// did not respond with a server hello.
+func hello(addr string, hostname string, vers uint16, suites []uint16, groups []tls.CurveID, alpn []string) *serverHello {
+	dialConn, err := net.DialTimeout("tcp", addr, 2*time.Second)
+	if err != nil {
+		return nil
+	}
+	config := tls.Config{
+		ServerName:         hostname,
+		InsecureSkipVerify: true,
+	}
+	uTlsConn := tls.UClient(dialConn, &config, tls.HelloCustom)
+	defer uTlsConn.Close()
 
-	findings := check(oldVersion, weakSuites)
-	if findings != "" {
-		return findings, true
+	min := vers
+	if min == tls.VersionTLS13 {
+		// 1.3 can't be lowest, not allowed by utls lib.
+		min = tls.VersionTLS12
+	}
+	if alpn == nil {
+		alpn = []string{"http/1.1"}
+	}
+	keyShares := []tls.KeyShare{{Group: tls.CurveID(tls.GREASE_PLACEHOLDER), Data: []byte{0}}}
+	for _, group := range groups {
+		keyShares = append(keyShares, tls.KeyShare{Group: group})
+	}
+	// most of this taken from the utls example:
+	spec := tls.ClientHelloSpec{
+		TLSVersMax:   vers,
+		TLSVersMin:   min,
+		CipherSuites: suites,
+		Extensions: []tls.TLSExtension{
+			&tls.SNIExtension{},
+			&tls.SupportedCurvesExtension{Curves: groups},
+			&tls.SupportedPointsExtension{SupportedPoints: []byte{0}}, // uncompressed
+			&tls.SessionTicketExtension{},
+			&tls.ALPNExtension{AlpnProtocols: alpn},
+			&tls.SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: []tls.SignatureScheme{
+				tls.ECDSAWithP256AndSHA256,
+				tls.PSSWithSHA256,
+				tls.PKCS1WithSHA256,
+				tls.ECDSAWithP384AndSHA384,
+				tls.PSSWithSHA384,
+				tls.PKCS1WithSHA384,
+				tls.PSSWithSHA512,
+				tls.PKCS1WithSHA512,
+				tls.ECDSAWithSHA1,
+				tls.PKCS1WithSHA1}},
+			&tls.KeyShareExtension{KeyShares: keyShares},
+			&tls.PSKKeyExchangeModesExtension{Modes: []uint8{1}}, // pskModeDHE
+			&tls.SupportedVersionsExtension{Versions: []uint16{vers}},
+		},
+		GetSessionID: nil,
 	}
-	findings = check(oldVersion, allSuites)
-	if findings != "" {
-		return findings, true
+	err = uTlsConn.ApplyPreset(&spec)
+	if err != nil {
+		return nil
 	}
-	findings = check(anyVersion, weakSuites)
-	if findings != "" {
-		return findings, true
+	_ = uTlsConn.SetDeadline(time.Now().Add(2 * time.Second))
+	_ = uTlsConn.Handshake()
+	sh := uTlsConn.HandshakeState.ServerHello
+	if sh == nil {
+		return nil
 	}
+	negotiated := sh.Vers
+	if sh.SupportedVersion != 0 {
+		negotiated = sh.SupportedVersion
+	}
+	alpnProto := sh.AlpnProtocol
+	if alpnProto == "" {
+		// TLS v1.3 sends ALPN in the encrypted extensions, so it's only known if the handshake completed
+		alpnProto = uTlsConn.ConnectionState().NegotiatedProtocol
+	}
+	return &serverHello{version: negotiated, suite: sh.CipherSuite, alpn: alpnProto}
+}
 
-	return "", false
+// suitesFor gives the subset of allSuites that can be negotiated for a version
+func suitesFor(vers uint16) []uint16 {
+	suites := make([]uint16, 0)
+	for _, suite := range allSuites {
+		if (vers == tls.VersionTLS13) == tls13Suites[suite] {
+			suites = append(suites, suite)
+		}
+	}
+	return suites
+}
+
+// ecdheSuitesFor is suitesFor limited to suites using an ephemeral (EC)DH key exchange, all v1.3 suites do
+func ecdheSuitesFor(vers uint16) []uint16 {
+	suites := make([]uint16, 0)
+	for _, suite := range suitesFor(vers) {
+		if tls13Suites[suite] || strings.HasPrefix(ctls.CipherSuiteName(suite), "TLS_ECDHE_") {
+			suites = append(suites, suite)
+		}
+	}
+	return suites
 }
 
 var (
@@ -137,6 +247,28 @@ var (
 	oldVersion = []uint16{tls.VersionTLS10}
 )
 
+var versionNames = map[uint16]string{
+	tls.VersionTLS10: "TLS v1.0",
+	tls.VersionTLS11: "TLS v1.1",
+	tls.VersionTLS12: "TLS v1.2",
+	tls.VersionTLS13: "TLS v1.3",
+}
+
+var allGroups = []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384, tls.CurveP521}
+
+var groupNames = map[tls.CurveID]string{
+	tls.X25519:    "X25519",
+	tls.CurveP256: "P-256",
+	tls.CurveP384: "P-384",
+	tls.CurveP521: "P-521",
+}
+
+var tls13Suites = map[uint16]bool{
+	tls.TLS_AES_128_GCM_SHA256:       true,
+	tls.TLS_AES_256_GCM_SHA384:       true,
+	tls.TLS_CHACHA20_POLY1305_SHA256: true,
+}
+
 var allSuites = []uint16{
 	tls.TLS_RSA_WITH_RC4_128_SHA,
 	tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA,
@@ -9,9 +9,11 @@ import (
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
 	"log"
+	"net"
 	"os"
 	"regexp"
 	"strings"
+	"sync"
 )
 
 type Config struct {
@@ -20,13 +22,36 @@ type Config struct {
 	ExpectedVersionPrefix string   `yaml:"expected_version_prefix"`
 	ApiNodes              []string `yaml:"api_nodes"`
 	P2pNodes              []string `yaml:"p2p_nodes"`
+	P2pObserve            int      `yaml:"p2p_observe"`     // seconds: keep p2p sessions open to measure block stream, default 0 (disabled)
+	P2pSyncBlocks         int      `yaml:"p2p_sync_blocks"` // number of blocks to request when testing sync from LIB, default 0 (disabled)
+	HistoryNodes          []string `yaml:"history_nodes"`
+	HistoryAccount        string   `yaml:"history_account"` // required with history_nodes, should have regular activity
+	HistoryMaxLag         int      `yaml:"history_max_lag"` // seconds: newest action for history_account must be within this of head, default 3600
+	ResolveAll            bool     `yaml:"resolve_all"`     // test every address a hostname resolves to
+	DualStack             bool     `yaml:"dual_stack"`      // test reachability over IPv4 and IPv6 separately
+	DnsCheck              bool     `yaml:"dns_check"`
+	DnsResolver           string   `yaml:"dns_resolver"`      // host:port, default is the first nameserver in /etc/resolv.conf
+	Origin                string   `yaml:"origin"`            // label for where checks are run from, default is found using the public address
+	IpProviderUrls        []string `yaml:"ip_providers"`      // services returning the public address as text, default DefaultIpProviders
+	MaxConcentration      int      `yaml:"max_concentration"` // percent: warn if more than this many nodes share a provider, default 50
+	Scoring               Scoring  `yaml:"scoring"`
+	GradeWindow           int      `yaml:"grade_window"` // number of recent runs averaged for each node's grade, default 24
 	OutputDir             string   `yaml:"output_dir"`
 	Region                string   `yaml:"region"`
 	DarkTheme             bool     `yaml:"dark_theme"`
+	JsonV2                bool     `yaml:"json_v2"`      // also write the v2 report layout to json/v2/
+	CdnAssets             bool     `yaml:"cdn_assets"`   // load third-party libraries from their CDN instead of writing them
+	TemplateDir           string   `yaml:"template_dir"` // local directory or s3 url with custom templates and assets
 
 	Bucket  string `yaml:"-"`
 	Prefix  string `yaml:"-"`
 	Geolite string `yaml:"-"`
+	AsnDb   string `yaml:"-"`
+
+	// IpProviders is built from ip_providers if empty, other implementations can be set before calling Validate
+	IpProviders []IpProvider `yaml:"-"`
+	originOnce  sync.Once
+	origin      string
 
 	P2pAlerts       *P2pAlerts `yaml:"-"`
 	ApiAlerts       *ApiAlerts `yaml:"-"`
@@ -72,6 +97,12 @@ func (c *Config) Validate() error {
 		}
 		c.ApiNodes[i] = strings.TrimRight(c.ApiNodes[i], "/")
 	}
+	for i := range c.HistoryNodes {
+		if !strings.HasPrefix(c.HistoryNodes[i], "http") {
+			formatErrs = append(formatErrs, "malformed history'"+c.HistoryNodes[i]+"' missing http(s) prefix")
+		}
+		c.HistoryNodes[i] = strings.TrimRight(c.HistoryNodes[i], "/")
+	}
 	r := regexp.MustCompile(`\w+:\d+`)
 	for i := range c.P2pNodes {
 		if !r.MatchString(c.P2pNodes[i]) {
@@ -83,6 +114,21 @@ func (c *Config) Validate() error {
 		return errors.New(strings.Join(formatErrs, ", "))
 	}
 
+	nodes := make([]string, 0)
+	nodes = append(nodes, c.ApiNodes...)
+	nodes = append(nodes, c.P2pNodes...)
+	nodes = append(nodes, c.HistoryNodes...)
+	if err := c.Scoring.validate(nodes); err != nil {
+		return err
+	}
+
+	if c.GradeWindow < 1 {
+		c.GradeWindow = 24
+	}
+	if c.GradeWindow > 500 {
+		return errors.New("grade_window must be 500 or less")
+	}
+
 	if c.OutputDir == "" {
 		c.OutputDir = "."
 	}
@@ -90,6 +136,55 @@ func (c *Config) Validate() error {
 		c.OutputDir = c.OutputDir[:len(c.OutputDir)-2]
 	}
 
+	if c.P2pObserve < 0 {
+		c.P2pObserve = 0
+	}
+	// the lambda timeout, and the one minute API deadline, limit how long this can reasonably be.
+	if c.P2pObserve > 45 {
+		return errors.New("p2p_observe must be 45 seconds or less")
+	}
+
+	if c.P2pSyncBlocks < 0 {
+		c.P2pSyncBlocks = 0
+	}
+	if c.P2pSyncBlocks > 1000 {
+		return errors.New("p2p_sync_blocks must be 1000 or less")
+	}
+
+	// there isn't an account that is certain to have recent actions on every chain, a quiet account would raise
+	// false history lag alarms
+	if len(c.HistoryNodes) > 0 && c.HistoryAccount == "" {
+		return errors.New("history_account is required when history_nodes are set")
+	}
+	if c.HistoryMaxLag < 1 {
+		c.HistoryMaxLag = 3600
+	}
+
+	if c.DnsCheck {
+		if c.DnsResolver == "" {
+			c.DnsResolver = defaultResolver()
+		}
+		if _, _, err := net.SplitHostPort(c.DnsResolver); err != nil {
+			c.DnsResolver = net.JoinHostPort(c.DnsResolver, "53")
+		}
+	}
+
+	if len(c.IpProviders) == 0 {
+		if len(c.IpProviderUrls) == 0 {
+			c.IpProviderUrls = DefaultIpProviders
+		}
+		for _, u := range c.IpProviderUrls {
+			c.IpProviders = append(c.IpProviders, HttpIpProvider(u))
+		}
+	}
+
+	if c.MaxConcentration < 1 {
+		c.MaxConcentration = 50
+	}
+	if c.MaxConcentration > 100 {
+		return errors.New("max_concentration is a percentage, must be 100 or less")
+	}
+
 	if c.FlapSuppression < 1 {
 		c.FlapSuppression = 4
 	}
@@ -207,22 +302,26 @@ func (c *Config) Validate() error {
 	// clear old text to prevent duplicate info
 	for k := range c.ApiAlerts.State {
 		c.ApiAlerts.State[k].HealthReason = ""
+		c.ApiAlerts.State[k].HealthFindings = nil
 		c.ApiAlerts.State[k].SecurityReason = ""
+		c.ApiAlerts.State[k].SecurityFindings = nil
 	}
 	for k := range c.P2pAlerts.State {
 		c.P2pAlerts.State[k].Reason = ""
+		c.P2pAlerts.State[k].Findings = nil
 	}
 	return nil
 }
 
 func GetConfig() (*Config, error) {
 	var (
-		err                           error
-		configFile, confFile, geolite string
+		err                                error
+		configFile, confFile, geolite, asn string
 	)
 
 	flag.StringVar(&confFile, "config", "config.yml", "yaml config file to load, can be local file, or S3 uri, or ENV var: CONFIG")
 	flag.StringVar(&geolite, "db", "GeoLite2-Country.mmdb", "geo lite database to open")
+	flag.StringVar(&asn, "asn", "GeoLite2-ASN.mmdb", "geo lite ASN database, optional, used for the hosting provider report")
 	flag.Parse()
 
 	switch true {
@@ -265,6 +364,7 @@ func GetConfig() (*Config, error) {
 		c.Region = "us-east-1"
 	}
 	c.Geolite = geolite
+	c.AsnDb = asn
 	// the telegram key is sensitive, *only* allow via ENV var, should use encrypted parameter in AWS passed to lambda
 	c.TelegramKey = os.Getenv("TELEGRAM")
 
@@ -0,0 +1,296 @@
+package fiohealth
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/xitongsys/parquet-go/writer"
+	"io"
+	"io/ioutil"
+	"log"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runFile matches the json saved for each run, named by the unix time of the run
+var runFile = regexp.MustCompile(`^(\d+)\.json$`)
+
+// ApiRow is a flattened API result, one for each node in each run from each test origin. The parquet tags also name
+// the csv columns.
+type ApiRow struct {
+	RunTimestamp       int64   `parquet:"name=run_timestamp, type=INT64"`
+	RunTime            string  `parquet:"name=run_time, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Origin             string  `parquet:"name=origin, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Node               string  `parquet:"name=node, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Healthy            bool    `parquet:"name=healthy, type=BOOLEAN"`
+	Error              string  `parquet:"name=error, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ErrorFor           string  `parquet:"name=error_for, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Version            string  `parquet:"name=version, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	RequestLatencyMs   int64   `parquet:"name=request_latency_ms, type=INT64"`
+	HeadBlockLatencyMs int64   `parquet:"name=head_block_latency_ms, type=INT64"`
+	PermissiveCors     bool    `parquet:"name=permissive_cors, type=BOOLEAN"`
+	TlsVersionOk       bool    `parquet:"name=tls_ver_ok, type=BOOLEAN"`
+	TlsCipherOk        bool    `parquet:"name=tls_cipher_ok, type=BOOLEAN"`
+	CertDaysLeft       int64   `parquet:"name=cert_days_left, type=INT64"`
+	ExposedEndpoints   string  `parquet:"name=exposed_endpoints, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Findings           int32   `parquet:"name=findings, type=INT32"`
+	Score              float32 `parquet:"name=score, type=FLOAT"`
+	Health             int32   `parquet:"name=health, type=INT32"`
+	Grade              string  `parquet:"name=grade, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Country            string  `parquet:"name=country, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Provider           string  `parquet:"name=provider, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Asn                int64   `parquet:"name=asn, type=INT64"`
+}
+
+// P2pRow is a flattened p2p result, one for each peer in each run from each test origin
+type P2pRow struct {
+	RunTimestamp       int64   `parquet:"name=run_timestamp, type=INT64"`
+	RunTime            string  `parquet:"name=run_time, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Origin             string  `parquet:"name=origin, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Peer               string  `parquet:"name=peer, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Healthy            bool    `parquet:"name=healthy, type=BOOLEAN"`
+	Reachable          bool    `parquet:"name=reachable, type=BOOLEAN"`
+	Status             string  `parquet:"name=status, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Error              string  `parquet:"name=error, type=BYTE_ARRAY, convertedtype=UTF8"`
+	HeadBlockLatencyMs int64   `parquet:"name=head_block_latency_ms, type=INT64"`
+	TookMs             int64   `parquet:"name=took_ms, type=INT64"`
+	Agent              string  `parquet:"name=agent, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	NetworkVersion     int32   `parquet:"name=network_version, type=INT32"`
+	HeadNum            int64   `parquet:"name=head_num, type=INT64"`
+	LibNum             int64   `parquet:"name=lib_num, type=INT64"`
+	BlocksPerSec       float64 `parquet:"name=blocks_per_sec, type=DOUBLE"`
+	MissingBlocks      int32   `parquet:"name=missing_blocks, type=INT32"`
+	SyncBlocksPerSec   float64 `parquet:"name=sync_blocks_per_sec, type=DOUBLE"`
+	Findings           int32   `parquet:"name=findings, type=INT32"`
+	Score              float32 `parquet:"name=score, type=FLOAT"`
+	Health             int32   `parquet:"name=health, type=INT32"`
+	Grade              string  `parquet:"name=grade, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Country            string  `parquet:"name=country, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Provider           string  `parquet:"name=provider, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Asn                int64   `parquet:"name=asn, type=INT64"`
+}
+
+// Export holds the rows for the runs between From and To
+type Export struct {
+	From time.Time
+	To   time.Time
+	Runs int
+	Api  []*ApiRow
+	P2p  []*P2pRow
+}
+
+// NewExport reads the json saved for each run from a local output directory or an s3://bucket/prefix url, keeping
+// the runs from `from` until (not including) `to`. On S3 the json directory is listed, if that isn't allowed
+// json/index.json is used, which only has the recent runs.
+func NewExport(source string, region string, from time.Time, to time.Time) (*Export, error) {
+	source = strings.TrimRight(source, "/")
+	var (
+		names []string
+		read  func(name string) ([]byte, error)
+		err   error
+	)
+	if strings.HasPrefix(source, "s3://") {
+		if region == "" {
+			region = "us-east-1"
+		}
+		parts := strings.SplitN(strings.TrimPrefix(source, "s3://"), "/", 2)
+		bucket, prefix := parts[0], "json/"
+		if len(parts) > 1 && parts[1] != "" {
+			prefix = parts[1] + "/json/"
+		}
+		read = func(name string) ([]byte, error) {
+			return S3Get(bucket, prefix+name, region)
+		}
+		names, err = S3List(bucket, prefix, region)
+		if err != nil {
+			log.Println("could not list " + source + "/json, using the index: " + err.Error())
+			var b []byte
+			b, err = read("index.json")
+			if err != nil {
+				return nil, err
+			}
+			err = json.Unmarshal(b, &names)
+			if err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		read = func(name string) ([]byte, error) {
+			return ioutil.ReadFile(source + "/json/" + name)
+		}
+		files, err := ioutil.ReadDir(source + "/json")
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			names = append(names, f.Name())
+		}
+	}
+
+	e := &Export{From: from, To: to, Api: make([]*ApiRow, 0), P2p: make([]*P2pRow, 0)}
+	for _, name := range names {
+		m := runFile.FindStringSubmatch(name)
+		if m == nil {
+			continue
+		}
+		ts, _ := strconv.ParseInt(m[1], 10, 64)
+		if ts < from.Unix() || ts >= to.Unix() {
+			continue
+		}
+		b, err := read(name)
+		if err != nil {
+			log.Println("could not read " + name + ": " + err.Error())
+			continue
+		}
+		run := FinalResult{}
+		err = json.Unmarshal(b, &run)
+		if err != nil {
+			log.Println("could not parse " + name + ": " + err.Error())
+			continue
+		}
+		e.add(ts, run)
+	}
+	sort.SliceStable(e.Api, func(i, j int) bool {
+		return e.Api[i].RunTimestamp < e.Api[j].RunTimestamp
+	})
+	sort.SliceStable(e.P2p, func(i, j int) bool {
+		return e.P2p[i].RunTimestamp < e.P2p[j].RunTimestamp
+	})
+	return e, nil
+}
+
+// add flattens a run, only the hostname results are used, not the results for each address
+func (e *Export) add(ts int64, run FinalResult) {
+	e.Runs += 1
+	runTime := time.Unix(ts, 0).UTC().Format(time.RFC3339)
+	for _, r := range run.Api {
+		row := &ApiRow{
+			RunTimestamp:       ts,
+			RunTime:            runTime,
+			Origin:             r.FromGeo,
+			Node:               r.Node,
+			Healthy:            !r.HadError,
+			Error:              r.Error,
+			ErrorFor:           r.ErrorFor,
+			Version:            r.NodeVer,
+			RequestLatencyMs:   r.RequestLatency,
+			HeadBlockLatencyMs: r.HeadBlockLatency,
+			PermissiveCors:     r.PermissiveCors,
+			TlsVersionOk:       r.TlsVerOk,
+			TlsCipherOk:        r.TlsCipherOk,
+			ExposedEndpoints:   strings.Join(r.Exposed(), ","),
+			Findings:           int32(len(r.Findings)),
+			Score:              r.Score,
+			Health:             int32(r.Health),
+			Grade:              r.Grade,
+		}
+		if r.Cert != nil && len(r.Cert.Chain) > 0 {
+			row.CertDaysLeft = r.Cert.Chain[0].DaysLeft
+		}
+		if r.Location != nil {
+			row.Country, row.Provider, row.Asn = r.Location.Country, r.Location.Provider, int64(r.Location.Asn)
+		}
+		e.Api = append(e.Api, row)
+	}
+	for _, p := range run.P2p {
+		row := &P2pRow{
+			RunTimestamp:       ts,
+			RunTime:            runTime,
+			Origin:             p.FromGeo,
+			Peer:               p.Peer,
+			Healthy:            p.Healthy,
+			Reachable:          p.Reachable,
+			Status:             string(p.Status),
+			Error:              p.ErrMsg,
+			HeadBlockLatencyMs: p.HeadBlockLatency,
+			TookMs:             p.TookMs,
+			Findings:           int32(len(p.Findings)),
+			Score:              p.Score,
+			Health:             int32(p.Health),
+			Grade:              p.Grade,
+		}
+		// older runs only have whole seconds
+		if row.TookMs == 0 {
+			row.TookMs = p.Took * 1000
+		}
+		if p.Handshake != nil {
+			row.Agent = p.Handshake.Agent
+			row.NetworkVersion = int32(p.Handshake.NetworkVersion)
+			row.HeadNum, row.LibNum = int64(p.Handshake.HeadNum), int64(p.Handshake.LibNum)
+		}
+		if p.Stream != nil {
+			row.BlocksPerSec, row.MissingBlocks = p.Stream.BlocksPerSec, int32(p.Stream.Missing)
+		}
+		if p.Sync != nil {
+			row.SyncBlocksPerSec = p.Sync.BlocksPerSec
+		}
+		if p.Location != nil {
+			row.Country, row.Provider, row.Asn = p.Location.Country, p.Location.Provider, int64(p.Location.Asn)
+		}
+		e.P2p = append(e.P2p, row)
+	}
+}
+
+// rowColumns gets the column names from the parquet tags of a row type
+func rowColumns(t reflect.Type) []string {
+	columns := make([]string, t.NumField())
+	for i := range columns {
+		for _, opt := range strings.Split(t.Field(i).Tag.Get("parquet"), ",") {
+			opt = strings.TrimSpace(opt)
+			if strings.HasPrefix(opt, "name=") {
+				columns[i] = strings.TrimPrefix(opt, "name=")
+			}
+		}
+	}
+	return columns
+}
+
+// WriteCsv writes rows (a slice of *ApiRow or *P2pRow) with a header
+func WriteCsv(w io.Writer, rows interface{}) error {
+	v := reflect.ValueOf(rows)
+	if v.Kind() != reflect.Slice {
+		return errors.New("rows must be a slice")
+	}
+	cw := csv.NewWriter(w)
+	err := cw.Write(rowColumns(v.Type().Elem().Elem()))
+	if err != nil {
+		return err
+	}
+	for i := 0; i < v.Len(); i++ {
+		row := v.Index(i).Elem()
+		record := make([]string, row.NumField())
+		for j := range record {
+			record[j] = fmt.Sprint(row.Field(j).Interface())
+		}
+		err = cw.Write(record)
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteParquet writes rows (a slice of *ApiRow or *P2pRow), snappy compressed
+func WriteParquet(w io.Writer, rows interface{}) error {
+	v := reflect.ValueOf(rows)
+	if v.Kind() != reflect.Slice {
+		return errors.New("rows must be a slice")
+	}
+	pw, err := writer.NewParquetWriterFromWriter(w, reflect.New(v.Type().Elem().Elem()).Interface(), 1)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < v.Len(); i++ {
+		err = pw.Write(v.Index(i).Interface())
+		if err != nil {
+			return err
+		}
+	}
+	return pw.WriteStop()
+}
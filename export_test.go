@@ -0,0 +1,114 @@
+package fiohealth
+
+import (
+	"bytes"
+	"encoding/csv"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
+	"reflect"
+	"testing"
+)
+
+var exportApiRows = []*ApiRow{
+	{
+		RunTimestamp: 1600000000, RunTime: "2020-09-13T12:26:40Z", Origin: "US-VA", Node: "https://fio.example.com",
+		Healthy: true, Version: "v2.0.0", RequestLatencyMs: 120, HeadBlockLatencyMs: 400, PermissiveCors: true,
+		TlsVersionOk: true, TlsCipherOk: true, CertDaysLeft: 60, Score: 0.2, Health: 100, Grade: "A", Country: "US",
+		Provider: "Example Hosting", Asn: 64500,
+	},
+	{
+		RunTimestamp: 1600000000, RunTime: "2020-09-13T12:26:40Z", Origin: "US-VA", Node: "https://down.example.com",
+		Error: "connection refused", ErrorFor: "initial connection", ExposedEndpoints: "net api, producer api",
+		Findings: 3, Score: 16, Health: 12, Grade: "F",
+	},
+}
+
+var exportP2pRows = []*P2pRow{
+	{
+		RunTimestamp: 1600000000, RunTime: "2020-09-13T12:26:40Z", Origin: "US-VA", Peer: "fio.example.com:9876",
+		Healthy: true, Reachable: true, Status: "ok", HeadBlockLatencyMs: 300, TookMs: 2100, Agent: "example",
+		NetworkVersion: 1210, HeadNum: 123456789, LibNum: 123456455, BlocksPerSec: 2.01, SyncBlocksPerSec: 350.5,
+		Health: 100, Grade: "A",
+	},
+}
+
+func TestParquetRoundTrip(t *testing.T) {
+	b := bytes.NewBuffer(nil)
+	if err := WriteParquet(b, exportApiRows); err != nil {
+		t.Fatal(err)
+	}
+	pf, err := buffer.NewBufferFile(b.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr, err := reader.NewParquetReader(pf, new(ApiRow), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.ReadStop()
+	if int(pr.GetNumRows()) != len(exportApiRows) {
+		t.Fatalf("expected %d rows, got %d", len(exportApiRows), pr.GetNumRows())
+	}
+	for _, e := range pr.Footer.Schema {
+		if e.GetName() == "node" && (e.GetType() != parquet.Type_BYTE_ARRAY || e.GetConvertedType() != parquet.ConvertedType_UTF8) {
+			t.Errorf("node should be a UTF8 byte array, got %v %v", e.GetType(), e.GetConvertedType())
+		}
+	}
+	rows := make([]ApiRow, pr.GetNumRows())
+	if err = pr.Read(&rows); err != nil {
+		t.Fatal(err)
+	}
+	for i := range rows {
+		if !reflect.DeepEqual(rows[i], *exportApiRows[i]) {
+			t.Errorf("row %d: expected %+v, got %+v", i, *exportApiRows[i], rows[i])
+		}
+	}
+
+	b.Reset()
+	if err = WriteParquet(b, exportP2pRows); err != nil {
+		t.Fatal(err)
+	}
+	pf, _ = buffer.NewBufferFile(b.Bytes())
+	p2p, err := reader.NewParquetReader(pf, new(P2pRow), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p2p.ReadStop()
+	peers := make([]P2pRow, p2p.GetNumRows())
+	if err = p2p.Read(&peers); err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 1 || !reflect.DeepEqual(peers[0], *exportP2pRows[0]) {
+		t.Errorf("expected %+v, got %+v", *exportP2pRows[0], peers)
+	}
+}
+
+func TestCsvRoundTrip(t *testing.T) {
+	b := bytes.NewBuffer(nil)
+	if err := WriteCsv(b, exportApiRows); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(b).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != len(exportApiRows)+1 {
+		t.Fatalf("expected a header and %d rows, got %d records", len(exportApiRows), len(records))
+	}
+	columns := make(map[string]int)
+	for i, name := range records[0] {
+		columns[name] = i
+	}
+	for _, name := range []string{"run_timestamp", "node", "error", "exposed_endpoints", "score", "asn"} {
+		if _, ok := columns[name]; !ok {
+			t.Errorf("missing column %s", name)
+		}
+	}
+	if got := records[2][columns["exposed_endpoints"]]; got != "net api, producer api" {
+		t.Errorf("expected the exposed endpoints to be quoted and kept together, got %q", got)
+	}
+	if got := records[1][columns["node"]]; got != "https://fio.example.com" {
+		t.Errorf("expected the node, got %q", got)
+	}
+}
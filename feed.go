@@ -0,0 +1,178 @@
+package fiohealth
+
+import (
+	"crypto/sha1"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxFeedEntries limits how many alarms are kept in feed.xml
+const maxFeedEntries = 100
+
+// the kinds of alarm
+const (
+	AlertHealth   = "health"
+	AlertSecurity = "security"
+	AlertP2p      = "p2p"
+)
+
+// AlertEvent is an alarm that was raised or cleared during a run, these are published in feed.xml
+type AlertEvent struct {
+	Node     string     // the alarm key: API url, p2p host:port, history url with /v1/history, may have an address added
+	Kind     string     // AlertHealth, AlertSecurity, or AlertP2p
+	Raised   bool       // false if the alarm was cleared
+	Reason   string     // summary of the findings, empty when cleared
+	Findings []*Finding // empty when cleared
+}
+
+func sortEvents(events []*AlertEvent) {
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].Node == events[j].Node {
+			return events[i].Kind < events[j].Kind
+		}
+		return events[i].Node < events[j].Node
+	})
+}
+
+// Title is the headline used in the feed
+func (e *AlertEvent) Title() string {
+	kind := "Health"
+	switch e.Kind {
+	case AlertSecurity:
+		kind = "Security"
+	case AlertP2p:
+		kind = "P2P health"
+	}
+	if !e.Raised {
+		return fmt.Sprintf("%s alarm cleared: %s", kind, e.Node)
+	}
+	return fmt.Sprintf("%s warning: %s - %s", kind, e.Node, e.Reason)
+}
+
+// atomFeed is the subset of Atom (RFC 4287) that is written, it is also read back to keep the older entries
+type atomFeed struct {
+	XMLName xml.Name     `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string       `xml:"title"`
+	Id      string       `xml:"id"`
+	Updated string       `xml:"updated"`
+	Author  atomAuthor   `xml:"author"`
+	Links   []atomLink   `xml:"link"`
+	Entries []*atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	Title    string         `xml:"title"`
+	Id       string         `xml:"id"`
+	Updated  string         `xml:"updated"`
+	Link     atomLink       `xml:"link"`
+	Category []atomCategory `xml:"category"`
+	Content  atomText       `xml:"content"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+// feedId makes a stable urn for the feed and its entries
+func feedId(parts ...string) string {
+	sum := sha1.Sum([]byte(strings.Join(parts, "\n")))
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+// WriteFeed adds the alarms raised and cleared during this run to feed.xml, linking each to the run's snapshot in
+// history/ (named by the unix time of the run). The newest maxFeedEntries are kept.
+func WriteFeed(conf *Config, events []*AlertEvent, snapshot string, run time.Time) error {
+	base := strings.TrimRight(conf.BaseUrl, "/")
+	if base != "" {
+		base += "/"
+	}
+	feed := &atomFeed{}
+	if b, err := conf.ReadOutput("feed.xml"); err == nil && len(b) > 0 {
+		if err = xml.Unmarshal(b, feed); err != nil {
+			conf.Log("could not read existing feed, starting a new one: " + err.Error())
+			feed = &atomFeed{}
+		}
+	}
+	feed.Title = "FIO " + conf.ReportTitle + " Health Alarms"
+	feed.Id = feedId("feed", conf.ReportTitle, conf.BaseUrl)
+	feed.Author = atomAuthor{Name: "fio-health"}
+	feed.Links = []atomLink{
+		{Href: base + "feed.xml", Rel: "self", Type: "application/atom+xml"},
+		{Href: base + "index.html", Rel: "alternate", Type: "text/html"},
+	}
+	if len(events) == 0 && feed.Updated != "" {
+		return nil
+	}
+	updated := run.UTC().Format(time.RFC3339)
+	feed.Updated = updated
+
+	origin := conf.TestOrigin()
+	link := base + "history/" + snapshot
+	entries := make([]*atomEntry, 0, len(events)+len(feed.Entries))
+	for _, e := range events {
+		entries = append(entries, &atomEntry{
+			Title:    e.Title(),
+			Id:       feedId(snapshot, origin, e.Kind, e.Node, fmt.Sprint(e.Raised)),
+			Updated:  updated,
+			Link:     atomLink{Href: link, Rel: "alternate", Type: "text/html"},
+			Category: []atomCategory{{Term: e.Kind}},
+			Content:  atomText{Type: "html", Body: feedContent(e, origin, link)},
+		})
+	}
+	entries = append(entries, feed.Entries...)
+	if len(entries) > maxFeedEntries {
+		entries = entries[:maxFeedEntries]
+	}
+	feed.Entries = entries
+
+	b, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return err
+	}
+	return conf.WriteOutput("feed.xml", append([]byte(xml.Header), b...))
+}
+
+// feedContent is the html body of an entry, it is escaped again when written as xml
+func feedContent(e *AlertEvent, origin string, link string) string {
+	sb := strings.Builder{}
+	if !e.Raised {
+		sb.WriteString("<p>The " + e.Kind + " alarm for " + html.EscapeString(e.Node) + " has cleared.</p>")
+	} else {
+		sb.WriteString("<p>" + html.EscapeString(e.Reason) + "</p>")
+		if len(e.Findings) > 0 {
+			sb.WriteString("<ul>")
+			for _, f := range e.Findings {
+				sb.WriteString(fmt.Sprintf("<li>%s (%s): %s", html.EscapeString(f.Severity), html.EscapeString(f.Check), html.EscapeString(f.Message)))
+				if f.Observed != "" {
+					sb.WriteString(", observed " + html.EscapeString(f.Observed))
+				}
+				if f.Threshold != "" {
+					sb.WriteString(", threshold " + html.EscapeString(f.Threshold))
+				}
+				sb.WriteString("</li>")
+			}
+			sb.WriteString("</ul>")
+		}
+	}
+	sb.WriteString(fmt.Sprintf(`<p>Test origin: %s, <a href="%s">report snapshot</a></p>`, html.EscapeString(origin), html.EscapeString(link)))
+	return sb.String()
+}
@@ -1,74 +1,137 @@
-// fhassets holds static content to be written to the web root. If using S3 it may be necessary to change the
-// filename being used to get around long cache times.
+// fhassets holds static content to be written to the web root. Asset file names include a hash of their content,
+// so a changed asset gets a new name and long cache times are safe. Old files are cleaned up using a manifest.
 package fhassets
 
 import (
-	fiohealth "github.com/fioprotocol/health"
-	"os"
+	"crypto/sha256"
+	"encoding/hex"
+	"html/template"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
 )
 
-func getFiles(darkTheme bool) map[string]*string {
+var webFont = regexp.MustCompile(`@import url\("https://fonts\.googleapis\.com/[^"]*"\);`)
+
+// Asset is a file used by the report
+type Asset struct {
+	Name      string // the name used in templates
+	Content   string
+	Cdn       string // third-party libraries can be loaded from a CDN instead
+	Integrity string // subresource integrity of the CDN copy
+}
+
+// FileName is the name the asset is written as, with the first 8 characters of the content's sha256 added
+func (a *Asset) FileName() string {
+	sum := sha256.Sum256([]byte(a.Content))
+	ext := path.Ext(a.Name)
+	return strings.TrimSuffix(a.Name, ext) + "." + hex.EncodeToString(sum[:4]) + ext
+}
+
+// Assets is the set of files used by the report for a theme
+type Assets struct {
+	files map[string]*Asset
+	cdn   bool
+}
+
+// NewAssets gets the assets for a theme. In CDN mode third-party libraries are loaded from their CDN, otherwise the
+// embedded copies are written alongside the report. LoadTemplates checks the embedded copies exist when CDN mode is
+// off.
+func NewAssets(darkTheme bool, cdn bool) *Assets {
+	a := &Assets{files: make(map[string]*Asset), cdn: cdn}
+	own := map[string]string{
+		"check.svg":         CheckSvg,
+		"exc.svg":           ExcSvg,
+		"slash.svg":         SlashSvg,
+		"tri.svg":           TriSvg,
+		"chart.svg":         ChartSvg,
+		"bootstrap.min.css": BootstrapCss,
+		"chartv2.js":        ChartJs,
+	}
 	if darkTheme {
-		return map[string]*string{
-			"check.svg":         &CheckSvgDark,
-			"exc.svg":           &ExcSvgDark,
-			"slash.svg":         &SlashSvgDark,
-			"tri.svg":           &TriSvgDark,
-			"chart.svg":         &ChartSvgDark,
-			"bootstrap.min.css": &BootstrapCssDark,
-			"chartv2.js":        &ChartJs,
+		own = map[string]string{
+			"check.svg":         CheckSvgDark,
+			"exc.svg":           ExcSvgDark,
+			"slash.svg":         SlashSvgDark,
+			"tri.svg":           TriSvgDark,
+			"chart.svg":         ChartSvgDark,
+			"bootstrap.min.css": BootstrapCssDark,
+			"chartv2.js":        ChartJs,
+		}
+	}
+	for name, content := range own {
+		// the theme loads its font from google, without it the font falls back to the system font
+		if !cdn && name == "bootstrap.min.css" {
+			content = webFont.ReplaceAllString(content, "")
 		}
+		a.files[name] = &Asset{Name: name, Content: content}
 	}
-	return map[string]*string{
-		"check.svg":         &CheckSvg,
-		"exc.svg":           &ExcSvg,
-		"slash.svg":         &SlashSvg,
-		"tri.svg":           &TriSvg,
-		"chart.svg":         &ChartSvg,
-		"bootstrap.min.css": &BootstrapCss,
-		"chartv2.js":        &ChartJs,
+	for _, v := range vendorAssets {
+		a.files[v.name] = &Asset{Name: v.name, Content: vendored[v.name], Cdn: v.cdn, Integrity: v.integrity}
 	}
+	return a
 }
 
-// WriteLocalAssets writes our assets if they don't exist
-func WriteLocalAssets(darkTheme bool, dir string) error {
-	for name, content := range getFiles(darkTheme) {
-		f, err := os.OpenFile(dir+"/"+name, os.O_RDWR|os.O_CREATE, 0644)
-		if err != nil {
-			return err
-		}
-		s, err := f.Stat()
-		if err != nil {
-			f.Close()
-			return err
-		}
-		if s.Size() == 0 {
-			_, err = f.WriteString(*content)
-			if err != nil {
-				f.Close()
-				return err
-			}
+// local is true if the asset is written alongside the report
+func (a *Assets) local(f *Asset) bool {
+	return f.Content != "" && !(a.cdn && f.Cdn != "")
+}
+
+// Local lists the assets that are written alongside the report, sorted by name
+func (a *Assets) Local() []*Asset {
+	files := make([]*Asset, 0)
+	for _, f := range a.files {
+		if a.local(f) {
+			files = append(files, f)
 		}
-		f.Close()
 	}
-	return nil
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name < files[j].Name
+	})
+	return files
 }
 
-// WriteS3Assets writes our assets to S3 if they don't exist
-func WriteS3Assets(darkTheme bool, bucket, prefix, region string) error {
-	var err error
-	for name, content := range getFiles(darkTheme) {
-		b, _ := fiohealth.S3Get(bucket, prefix+"/"+name, region)
-		// already exists
-		if len(b) > 0 {
-			continue
-		}
-		err = fiohealth.S3Put(bucket, prefix+"/"+name, []byte(*content), region)
-		if err != nil {
-			return err
-		}
+// Funcs provides the asset and sri template functions. asset gives the url for an asset, relative to base (for
+// pages in a sub-directory) or its CDN. sri gives the integrity and crossorigin attributes for a third-party library.
+func (a *Assets) Funcs(base string) template.FuncMap {
+	return template.FuncMap{
+		"asset": func(name string) string {
+			f := a.files[name]
+			switch {
+			case f == nil:
+				return base + name
+			case a.local(f):
+				return base + f.FileName()
+			}
+			return f.Cdn
+		},
+		"sri": func(name string) template.HTMLAttr {
+			f := a.files[name]
+			if f == nil || f.Cdn == "" {
+				return ""
+			}
+			integrity := f.Integrity
+			if a.local(f) {
+				integrity = sri(f.Content)
+			}
+			if integrity == "" {
+				return ""
+			}
+			return template.HTMLAttr(`integrity="` + integrity + `" crossorigin="anonymous"`)
+		},
 	}
-	return nil
+}
+
+// WriteLocalAssets writes any new or changed assets to the directory, and removes ones that are no longer used (see
+// Manifest)
+func WriteLocalAssets(assets *Assets, dir string) error {
+	return writeAssets(assets, localStore(dir))
+}
+
+// WriteS3Assets writes any new or changed assets to S3, and removes ones that are no longer used (see Manifest)
+func WriteS3Assets(assets *Assets, bucket, prefix, region string) error {
+	return writeAssets(assets, s3Store{bucket: bucket, prefix: prefix, region: region})
 }
 
 // Light theme:
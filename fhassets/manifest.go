@@ -0,0 +1,272 @@
+package fhassets
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	fiohealth "github.com/fioprotocol/health"
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// manifestFile is written in each directory that holds assets
+	manifestFile = "assets.json"
+	// assetRetention is the minimum time a replaced asset is kept, after that it is removed once no html file in the
+	// same directory (such as the snapshots in history/) refers to it
+	assetRetention = 7 * 24 * time.Hour
+)
+
+// legacyAssets were written without a content hash before the manifest was added
+var legacyAssets = []string{"check.svg", "exc.svg", "slash.svg", "tri.svg", "chart.svg", "bootstrap.min.css", "chartv2.js"}
+
+// Manifest records the assets written to a directory, so that changed assets are replaced and ones that are no
+// longer used can be removed.
+type Manifest struct {
+	Files    map[string]*ManifestEntry `json:"files"`    // by asset name
+	Obsolete map[string]int64          `json:"obsolete"` // file name, and when it was replaced (unix time)
+}
+
+// ManifestEntry is the current file for an asset
+type ManifestEntry struct {
+	File   string `json:"file"`
+	Sha256 string `json:"sha256"`
+}
+
+// Hash is the hex sha256 of the asset's content
+func (a *Asset) Hash() string {
+	sum := sha256.Sum256([]byte(a.Content))
+	return hex.EncodeToString(sum[:])
+}
+
+// assetStore is where assets are written, either a local directory or S3
+type assetStore interface {
+	read(name string) ([]byte, error)
+	write(name string, b []byte) error
+	remove(name string) error
+	// list gets the names of the files in a sub-directory
+	list(dir string) ([]string, error)
+	// current is true if the file exists with the expected content
+	current(name string, sha string) bool
+}
+
+type localStore string
+
+func (dir localStore) read(name string) ([]byte, error) {
+	return ioutil.ReadFile(string(dir) + "/" + name)
+}
+
+func (dir localStore) write(name string, b []byte) error {
+	return ioutil.WriteFile(string(dir)+"/"+name, b, 0644)
+}
+
+func (dir localStore) remove(name string) error {
+	err := os.Remove(string(dir) + "/" + name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (dir localStore) list(sub string) ([]string, error) {
+	files, err := ioutil.ReadDir(string(dir) + "/" + sub)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0)
+	for _, f := range files {
+		if !f.IsDir() {
+			names = append(names, f.Name())
+		}
+	}
+	return names, nil
+}
+
+func (dir localStore) current(name string, sha string) bool {
+	b, err := dir.read(name)
+	if err != nil {
+		return false
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]) == sha
+}
+
+type s3Store struct {
+	bucket, prefix, region string
+}
+
+// key is the S3 key for a file, the prefix may be empty
+func (s s3Store) key(name string) string {
+	if s.prefix == "" {
+		return name
+	}
+	return s.prefix + "/" + name
+}
+
+func (s s3Store) read(name string) ([]byte, error) {
+	return fiohealth.S3Get(s.bucket, s.key(name), s.region)
+}
+
+func (s s3Store) write(name string, b []byte) error {
+	return fiohealth.S3Put(s.bucket, s.key(name), b, s.region)
+}
+
+func (s s3Store) remove(name string) error {
+	return fiohealth.S3Delete(s.bucket, s.key(name), s.region)
+}
+
+func (s s3Store) list(sub string) ([]string, error) {
+	dir := s.prefix
+	if sub != "" {
+		dir = s.key(sub)
+	}
+	if dir != "" {
+		dir += "/"
+	}
+	keys, err := fiohealth.S3List(s.bucket, dir, s.region)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0)
+	for _, k := range keys {
+		if k != "" && !strings.Contains(k, "/") {
+			names = append(names, k)
+		}
+	}
+	return names, nil
+}
+
+// current only checks that the file exists, downloading every asset each run to compare would be expensive. The
+// manifest is trusted when it lists the same hash.
+func (s s3Store) current(name string, sha string) bool {
+	b, _ := s.read(name)
+	return len(b) > 0
+}
+
+// newStore opens a local directory, or an s3://bucket/prefix url
+func newStore(location string, region string) assetStore {
+	location = strings.TrimRight(location, "/")
+	if !strings.HasPrefix(location, "s3://") {
+		return localStore(location)
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	parts := strings.SplitN(strings.TrimPrefix(location, "s3://"), "/", 2)
+	s := s3Store{bucket: parts[0], region: region}
+	if len(parts) > 1 {
+		s.prefix = parts[1]
+	}
+	return s
+}
+
+// writeAssets writes any asset that has changed or is missing, marks replaced files as obsolete, removes obsolete
+// files once they are older than assetRetention and no longer referenced, and saves the manifest.
+func writeAssets(assets *Assets, store assetStore) error {
+	now := time.Now().UTC()
+	old := &Manifest{}
+	b, err := store.read(manifestFile)
+	if err == nil && len(b) > 0 {
+		if err = json.Unmarshal(b, old); err != nil {
+			log.Println("could not read asset manifest, rewriting all assets: " + err.Error())
+			old = &Manifest{}
+		}
+	} else {
+		// first run with a manifest, the files written before content hashes were used are no longer needed
+		old.Obsolete = make(map[string]int64)
+		for _, name := range legacyAssets {
+			old.Obsolete[name] = now.Unix()
+		}
+	}
+	m := &Manifest{Files: make(map[string]*ManifestEntry), Obsolete: old.Obsolete}
+	if m.Obsolete == nil {
+		m.Obsolete = make(map[string]int64)
+	}
+
+	for _, asset := range assets.Local() {
+		entry := &ManifestEntry{File: asset.FileName(), Sha256: asset.Hash()}
+		m.Files[asset.Name] = entry
+		// may have been replaced and then restored, such as switching themes
+		delete(m.Obsolete, entry.File)
+		if prev := old.Files[asset.Name]; prev != nil && *prev == *entry && store.current(entry.File, entry.Sha256) {
+			continue
+		}
+		if err = store.write(entry.File, []byte(asset.Content)); err != nil {
+			return err
+		}
+	}
+	for name, prev := range old.Files {
+		if current := m.Files[name]; current == nil || current.File != prev.File {
+			m.Obsolete[prev.File] = now.Unix()
+		}
+	}
+	expired := make([]string, 0)
+	for file, since := range m.Obsolete {
+		if now.Sub(time.Unix(since, 0)) >= assetRetention {
+			expired = append(expired, file)
+		}
+	}
+	if len(expired) > 0 {
+		expired = unreferenced(store, expired)
+	}
+	for _, file := range expired {
+		if err = store.remove(file); err != nil {
+			log.Println("could not remove obsolete asset " + file + ": " + err.Error())
+			continue
+		}
+		delete(m.Obsolete, file)
+	}
+
+	b, err = json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	// avoid rewriting the manifest when nothing has changed
+	if prev, _ := store.read(manifestFile); bytes.Equal(prev, b) {
+		return nil
+	}
+	return store.write(manifestFile, b)
+}
+
+// unreferenced filters files to the ones not used by any html file in the store's directory. If the html files can't
+// be listed nothing is considered unreferenced.
+func unreferenced(store assetStore, files []string) []string {
+	names, err := store.list("")
+	if err != nil {
+		log.Println("could not list html files, keeping obsolete assets: " + err.Error())
+		return nil
+	}
+	used := make(map[string]bool)
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".html") {
+			continue
+		}
+		b, err := store.read(name)
+		if err != nil {
+			log.Println("could not read " + name + ", keeping obsolete assets: " + err.Error())
+			return nil
+		}
+		for _, file := range files {
+			if !used[file] && referenced(string(b), file) {
+				used[file] = true
+			}
+		}
+	}
+	remove := make([]string, 0)
+	for _, file := range files {
+		if !used[file] {
+			remove = append(remove, file)
+		}
+	}
+	return remove
+}
+
+// referenced is true if file is used in html as a whole name, so check.svg doesn't match check.1a2b3c4d.svg
+func referenced(html string, file string) bool {
+	return regexp.MustCompile(`(^|["'/(=])` + regexp.QuoteMeta(file) + `($|["'?#)\s>])`).MatchString(html)
+}
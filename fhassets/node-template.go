@@ -0,0 +1,197 @@
+package fhassets
+
+// NodePage is the detail page written to nodes/<host>.html for each node, asset urls are relative to nodes/
+const NodePage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="UTF-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1, shrink-to-fit=no">
+  <title>{{.Node}} - FIO {{.Title}} Health</title>
+  <link rel="stylesheet" href="{{asset "bootstrap.min.css"}}">
+  <script src="{{asset "echarts.min.js"}}" {{sri "echarts.min.js"}}></script>
+</head>
+<body>
+<div class="container-fluid">
+  <div class="w-95 mx-auto" style="max-width: 1600px;">
+    <h1>{{.Node}}</h1>
+    <div class="text-info">{{if eq .Kind "api"}}API{{else if eq .Kind "p2p"}}P2P{{else}}History API{{end}} node, last run: {{.Updated}}</div>
+    <div><a href="../index.html">&larr; {{.Title}} Health</a></div>
+    <div><img src="../badges/{{.FileName}}.svg" alt="{{.Node}} status"> <small class="text-muted">embed: badges/{{.FileName}}.svg</small></div>
+    <div><br /></div>
+
+    <h2>Current Status</h2>
+    {{with .Api}}{{template "status" .}}{{end}}
+    {{with .P2p}}{{template "status" .}}{{end}}
+    {{with .History}}{{template "status" .}}{{end}}
+
+    {{with .Api}}
+    <table class="table table-sm table-borderless w-auto">
+      <tbody>
+        <tr><th scope="row">Version</th><td>{{.NodeVer}}</td></tr>
+        <tr><th scope="row">Response (ms)</th><td>{{.RequestLatency}}</td></tr>
+        <tr><th scope="row">Headblock Lag (ms)</th><td>{{.HeadBlockLatency}}</td></tr>
+        <tr><th scope="row">Permissive CORS</th><td>{{.PermissiveCors}}</td></tr>
+        {{with .Location}}<tr><th scope="row">Location</th><td>{{.CountryName}}{{if .Provider}}, {{.Provider}} (AS{{.Asn}}){{end}}</td></tr>{{end}}
+      </tbody>
+    </table>
+    {{if .Addresses}}
+    <h4>Addresses</h4>
+    <table class="table table-striped table-sm table-borderless w-auto">
+      <thead class="thead-dark"><tr><th>Address</th><th>Healthy</th><th>Response (ms)</th><th>Error</th></tr></thead>
+      <tbody>
+      {{range .Addresses}}<tr><td>{{.Ip}}</td><td>{{if .HadError}}<img src="{{asset "tri.svg"}}" alt="failed" width="28" height="28">{{else}}<img src="{{asset "check.svg"}}" alt="ok" width="28" height="28">{{end}}</td><td>{{.RequestLatency}}</td><td>{{.Error}}</td></tr>{{end}}
+      </tbody>
+    </table>
+    {{end}}
+
+    <h2>TLS</h2>
+    {{if .TlsNote}}<div class="text-warning">{{.TlsNote}}</div>{{end}}
+    {{with .Cert}}
+    <h4>Certificate</h4>
+    <table class="table table-sm table-borderless w-auto">
+      <tbody>
+        <tr><th scope="row">Chain</th><td>{{if .ChainOk}}valid{{else}}{{.VerifyError}}{{end}}{{if .IncompleteChain}}, incomplete{{end}}</td></tr>
+        <tr><th scope="row">Hostname</th><td>{{if .HostnameOk}}matches{{else}}does not match{{end}}</td></tr>
+        <tr><th scope="row">Key</th><td>{{.KeyType}} {{.KeyBits}}{{if .WeakKey}} (weak){{end}}</td></tr>
+        <tr><th scope="row">Revocation</th><td>{{.Revocation}}{{if .OcspStapled}}, OCSP stapled{{end}}</td></tr>
+        {{range .Notes}}<tr><th scope="row"></th><td class="text-warning">{{.}}</td></tr>{{end}}
+      </tbody>
+    </table>
+    <table class="table table-striped table-sm table-borderless">
+      <thead class="thead-dark"><tr><th>Subject</th><th>Issuer</th><th>Expires</th><th>Days Left</th><th>Signature</th></tr></thead>
+      <tbody>
+      {{range .Chain}}<tr><td>{{.Subject}}</td><td>{{.Issuer}}</td><td>{{.NotAfter}}</td><td>{{.DaysLeft}}</td><td>{{.SignatureAlgorithm}}</td></tr>{{end}}
+      </tbody>
+    </table>
+    {{end}}
+    {{with .TlsMatrix}}
+    <h4>Protocols and Cipher Suites</h4>
+    <table class="table table-sm table-borderless w-auto">
+      <thead><tr><th>Version</th><th>Cipher Suites</th></tr></thead>
+      <tbody>
+      {{range .Versions}}<tr><td>{{.Version}}</td><td>{{if .Supported}}{{$v := .}}{{range .Suites}}{{if $v.Weak .}}<span class="text-warning">{{.}}</span>{{else}}{{.}}{{end}}<br />{{end}}{{else}}not supported{{end}}</td></tr>{{end}}
+      </tbody>
+    </table>
+    <p>Key exchange groups: {{range $i, $e := .Groups}}{{if $i}}, {{end}}{{$e}}{{end}}</p>
+    <p>ALPN: {{range $i, $e := .Alpn}}{{if $i}}, {{end}}{{$e}}{{end}}</p>
+    {{end}}
+    {{end}}
+
+    {{with .P2p}}
+    <table class="table table-sm table-borderless w-auto">
+      <tbody>
+        <tr><th scope="row">Status</th><td>{{.Status}}</td></tr>
+        <tr><th scope="row">Headblock Lag (ms)</th><td>{{.HeadBlockLatency}}</td></tr>
+        {{with .Handshake}}
+        <tr><th scope="row">Agent</th><td>{{.Agent}}</td></tr>
+        <tr><th scope="row">Network Version</th><td>{{.NetworkVersion}}</td></tr>
+        <tr><th scope="row">OS</th><td>{{.Os}}</td></tr>
+        <tr><th scope="row">Head / LIB</th><td>{{.HeadNum}} / {{.LibNum}}</td></tr>
+        {{end}}
+        {{with .Stream}}<tr><th scope="row">Block Stream</th><td>{{.Blocks}} blocks in {{.Window}}s, {{printf "%.2f" .BlocksPerSec}}/s, jitter {{printf "%.0f" .Jitter}} ms, max gap {{.MaxGap}} ms, {{.Missing}} missing</td></tr>{{end}}
+        {{with .Sync}}<tr><th scope="row">Sync</th><td>{{.Received}} of {{.Requested}} blocks at {{printf "%.1f" .BlocksPerSec}}/s{{if .Error}}: {{.Error}}{{end}}</td></tr>{{end}}
+        {{with .Location}}<tr><th scope="row">Location</th><td>{{.CountryName}}{{if .Provider}}, {{.Provider}} (AS{{.Asn}}){{end}}</td></tr>{{end}}
+      </tbody>
+    </table>
+    {{end}}
+
+    {{with .History}}
+    <table class="table table-sm table-borderless w-auto">
+      <tbody>
+        <tr><th scope="row">Response (ms)</th><td>{{.RequestLatency}}</td></tr>
+        <tr><th scope="row">History Lag (ms)</th><td>{{.HistoryLag}}</td></tr>
+        <tr><th scope="row">Head Block</th><td>{{.HeadBlock}}</td></tr>
+        <tr><th scope="row">Last Action Block</th><td>{{.LastActionBlock}}</td></tr>
+        <tr><th scope="row">Get Transaction</th><td>{{.TransactionOk}}</td></tr>
+      </tbody>
+    </table>
+    {{end}}
+
+    <h2>Uptime</h2>
+    <table class="table table-striped table-sm table-borderless w-auto">
+      <thead class="thead-dark"><tr><th>Test Origin</th><th>Checks</th><th>Passed</th><th>Uptime</th></tr></thead>
+      <tbody>
+      {{range .Uptime}}<tr><td>{{.Origin}}</td><td>{{.Checks}}</td><td>{{.Passed}}</td><td>{{.Percent}}%</td></tr>{{end}}
+      </tbody>
+    </table>
+
+    <div id="latency" class="border-dark rounded-lg" style="max-width: 1000px;height:300px; background-color: #303030;"></div>
+    <br />
+    <div id="lag" class="border-dark rounded-lg" style="max-width: 1000px;height:300px; background-color: #303030;"></div>
+
+    {{if .Versions}}
+    <h2>Versions</h2>
+    <table class="table table-striped table-sm table-borderless w-auto">
+      <thead class="thead-dark"><tr><th>Version</th><th>Seen</th></tr></thead>
+      <tbody>
+      {{range .Versions}}<tr><td>{{.Version}}</td><td>{{.Seen}}</td></tr>{{end}}
+      </tbody>
+    </table>
+    {{end}}
+
+    <h2>Recent Incidents</h2>
+    {{if .Incidents}}
+    <table class="table table-striped table-sm table-borderless">
+      <thead class="thead-dark"><tr><th>Started</th><th>Ended</th><th>Failed Checks</th><th>Test Origin</th><th>Reason</th></tr></thead>
+      <tbody>
+      {{range .Incidents}}<tr><td>{{.Started}}</td><td {{if not .End}}class="text-warning"{{end}}>{{.Ended}}</td><td>{{.Checks}}</td><td>{{.Origin}}</td><td>{{.Reason}}</td></tr>{{end}}
+      </tbody>
+    </table>
+    {{else}}
+    <p>No failed checks in the recent reports.</p>
+    {{end}}
+  </div>
+</div>
+<script>
+  const samples = {{.Samples}};
+  const chart = function(id, title, stat) {
+    let series = new Map();
+    for (const s of samples) {
+      if (s[stat] === undefined) {
+        continue
+      }
+      if (!series.has(s.origin)) {
+        series.set(s.origin, [])
+      }
+      series.get(s.origin).push([s.timestamp * 1000, s[stat]])
+    }
+    if (series.size === 0) {
+      document.getElementById(id).remove();
+      return
+    }
+    let option = {
+      title: {text: title, textStyle: {color: 'rgba(255, 255, 255, 0.8)'}},
+      textStyle: {color: 'rgba(255, 255, 255, 0.8)'},
+      backgroundColor: '#303030',
+      tooltip: {trigger: 'axis'},
+      legend: {data: Array.from(series.keys()), textStyle: {color: 'rgba(255, 255, 255, 0.8)'}},
+      xAxis: {type: 'time'},
+      yAxis: {type: 'value'},
+      series: []
+    };
+    for (let [k, v] of series) {
+      option.series.push({name: k, type: 'line', data: v, lineStyle: {width: 3}});
+    }
+    echarts.init(document.getElementById(id)).setOption(option);
+  };
+  window.onload = function() {
+    chart('latency', 'Response (ms)', 'latency_ms');
+    chart('lag', {{if eq .Kind "history"}}'History Lag (ms)'{{else}}'Headblock Lag (ms)'{{end}}, 'lag_ms');
+  };
+</script>
+</body>
+</html>
+{{define "status"}}
+    <div>{{if .Grade}}Grade <span class="badge {{if eq .Grade "A"}}badge-success{{else if eq .Grade "B"}}badge-info{{else if eq .Grade "C" "D"}}badge-warning{{else}}badge-danger{{end}}">{{.Grade}}</span> (health {{.Health}}/100 over recent checks), {{end}}test origin {{.FromGeo}}</div>
+    <br />
+    {{if .Findings}}
+    <table class="table table-striped table-sm table-borderless">
+      <thead class="thead-dark"><tr><th>Severity</th><th>Category</th><th>Check</th><th>Finding</th><th>Observed</th><th>Threshold</th></tr></thead>
+      <tbody>
+      {{range .Findings}}<tr><td class="{{if eq .Severity "critical"}}text-danger{{else if eq .Severity "warning"}}text-warning{{end}}">{{.Severity}}</td><td>{{.Category}}</td><td>{{.Check}}</td><td>{{.Message}}</td><td>{{.Observed}}</td><td>{{.Threshold}}</td></tr>{{end}}
+      </tbody>
+    </table>
+    {{else}}
+    <p><img src="{{asset "check.svg"}}" alt="ok" width="28" height="28"> No findings</p>
+    {{end}}
+{{end}}`
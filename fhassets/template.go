@@ -7,9 +7,10 @@ const Report = `<!DOCTYPE html>
   <meta charset="UTF-8">
   <meta name="viewport" content="width=device-width, initial-scale=1, shrink-to-fit=no">
   <title>FIO {{.Description}} Health</title>
-  <link rel="stylesheet" href="bootstrap.min.css">
-  <link rel="stylesheet" href="https://unpkg.com/bootstrap-table@1.18.0/dist/bootstrap-table.min.css">
-  <script src="https://cdn.jsdelivr.net/npm/echarts@4.9.0/dist/echarts.js"></script>
+  <link rel="alternate" type="application/atom+xml" title="FIO {{.Description}} Health Alarms" href="feed.xml">
+  <link rel="stylesheet" href="{{asset "bootstrap.min.css"}}">
+  <link rel="stylesheet" href="{{asset "bootstrap-table.min.css"}}" {{sri "bootstrap-table.min.css"}}>
+  <script src="{{asset "echarts.min.js"}}" {{sri "echarts.min.js"}}></script>
   <style type="text/css">
     html {
       scroll-behavior: smooth;
@@ -49,6 +50,24 @@ const Report = `<!DOCTYPE html>
       </div>
     </div>
 
+    <div class="modal fade" id="tlsModal" tabindex="-1" aria-labelledby="tlsModalLabel" aria-hidden="true">
+      <div class="modal-dialog modal-lg">
+        <div class="modal-content">
+          <div class="modal-header">
+            <h5 class="modal-title" id="tlsModalLabel">TLS Details</h5>
+            <button type="button" class="close" data-dismiss="modal" aria-label="Close">
+              <span aria-hidden="true">&times;</span>
+            </button>
+          </div>
+          <div class="modal-body" id="tlsDetails">
+          </div>
+          <div class="modal-footer">
+            <button type="button" class="btn btn-secondary" data-dismiss="modal">Close</button>
+          </div>
+        </div>
+      </div>
+    </div>
+
 	<div>
       <br />
       <div id="chart" class="border-dark mx-auto rounded-lg" style="width: 1000px;height:300px; background-color: #303030;" hidden>
@@ -62,6 +81,7 @@ const Report = `<!DOCTYPE html>
         <thead class="thead-dark">
           <tr>
             <th scope="col">Host</th>
+            <th scope="col">Grade</th>
             <th scope="col">Version</th>
             <th scope="col">Healthy</th>
             <th scope="col">Errors</th>
@@ -70,56 +90,20 @@ const Report = `<!DOCTYPE html>
             <th scope="col"></th>
             <th scope="col" data-sortable="true">Headblock Lag (ms)</th>
             <th scope="col">CORS</th>
+            <th scope="col">Headers</th>
             <th scope="col">Strong TLS</th>
             <th scope="col">TLS Info</th>
+            <th scope="col">Certificate</th>
             <th class="text-center" scope="col">Security Warnings</th>
+            <th scope="col">IPv4 / IPv6</th>
+            <th scope="col">DNS</th>
             <th scope="col">Test Origin</th>
           </tr>
         </thead>
         <tbody>
         {{range .Api}}
-        <tr id="{{.Node}}">
-          <th scope="row" class="align-middle">{{.Node}}</th>
-          <th scope="row" {{if .WrongVersion}}class="align-middle text-warning"{{else}}class="align-middle"{{end}}>{{.NodeVer}}</th>
-          <td class="align-middle">{{if .HadError}}<img src="tri.svg" alt="failed" width="28" height="28">{{else}}<img src="check.svg" alt="ok" width="28" height="28">{{end}}</td>
-          <td class="text-info" style="max-width: 250px;"><div class="d-inline-block overflow-hidden" style="max-width: 245px;max-height: 40px;" >
-          <span data-toggle="tooltip" delay="0" trigger="hover focus" placement="right" title="{{.Error}}">
-              {{.Error}}
-          </span>
-          </div></td>
-          <td class="align-middle">
-            <div>
-              <button type="button" class="chart-button btn btn-outline-dark" onClick="graphLatency('{{.Node}}')">
-                <img src="chart.svg" alt="view latency chart" class="align-middle" width="20" height="20">
-              </button>
-             </div>
-          </td>
-          <td {{if gt .RequestLatency 2000}}class="align-middle text-warning"{{else}}class="align-middle"{{end}}>
-            <div>
-			  {{.RequestLatency}} 
-             </div>
-           </td>
-          <td class="align-middle">
-            <div>
-              <button type="button" class="chart-button btn btn-outline-dark" onClick="graphLatency('{{.Node}}', 'lag')">
-                <img src="chart.svg" alt="view latency chart" class="align-middle" width="20" height="20">
-              </button>
-             </div>
-          </td>
-          <td {{if gt .HeadBlockLatency 30000 }} class="text-warning align-middle"{{else}} class="align-middle"{{end}}>
-              {{.HeadBlockLatency}}
-             </div>
-          </td>
-          <td class="align-middle">{{if .PermissiveCors}}<img src="check.svg" alt="ok" width="28" height="28">{{else}}<img src="tri.svg" alt="failed" width="28" height="28">{{end}}</td>
-          <td class="align-middle">{{ if not .TlsVerOk}}<img src="slash.svg" alt="failed" width="28" height="28">{{else if not .TlsCipherOk}}<img src="slash.svg" alt="failed" width="28" height="28">{{else}}<img src="check.svg" alt="ok" width="28" height="28">{{end}}</td>
-          <td class="align-middle" style="max-width: 250px;"><div class="d-inline-block overflow-hidden" style="max-width: 245px;max-height: 40px;">
-          <span data-toggle="tooltip" delay="0" trigger="hover focus" placement="right" title="{{.TlsNote}}">
-              {{.TlsNote}}
-          </span>
-          </div></td>
-          <td class="text-center align-middle">{{if .ProducerExposed}}<img src="exc.svg" alt="failed" width="28" height="28">{{else if .NetExposed}}<img src="exc.svg" alt="failed" width="28" height="28">{{end}}</td>
-          <td>{{.FromGeo}}</td>
-        </tr>
+        {{template "apiRow" .}}
+        {{range .Addresses}}{{template "apiRow" .}}{{end}}
         {{ end }}
         </tbody>
       </table>
@@ -129,30 +113,115 @@ const Report = `<!DOCTYPE html>
         <thead class="thead-dark">
         <tr>
           <th scope="col">Host</th>
+          <th scope="col">Grade</th>
+          <th scope="col">Agent</th>
+          <th scope="col">Net Version</th>
           <th scope="col">Listening</th>
           <th scope="col">Healthy</th>
           <th scope="col">Errors</th>
           <th scope="col">Headblock Lag (ms)</th>
+          <th scope="col">Blocks/sec</th>
+          <th scope="col">Max Gap (ms)</th>
+          <th scope="col">Sync (blocks/sec)</th>
+          <th scope="col">IPv4 / IPv6</th>
+          <th scope="col">DNS</th>
           <th scope="col">Test Origin</th>
         </tr>
         </thead>
         <tbody>
         {{range .P2p}}
-        <tr id="{{.Peer}}">
-          <th scope="row">{{.Peer}}</th>
-          <td>{{if .Reachable}}<img src="check.svg" alt="ok" width="28" height="28">{{else}}<img src="tri.svg" alt="failed" width="28" height="28">{{end}}</td>
-          <td>{{if .Healthy}}<img src="check.svg" alt="ok" width="28" height="28">{{else}}<img src="tri.svg" alt="failed" width="28" height="28">{{end}}</td>
+        {{template "p2pRow" .}}
+        {{range .Addresses}}{{template "p2pRow" .}}{{end}}
+        {{end}}
+        </tbody>
+      </table>
+    {{if .History}}
+    <br />
+    <h2>History API</h2>
+      <table class="table table-striped table-sm table-hover table-borderless" data-toggle="table" data-search="true" data-custom-sort="customSort">
+        <thead class="thead-dark">
+        <tr>
+          <th scope="col">Host</th>
+          <th scope="col">Grade</th>
+          <th scope="col">Healthy</th>
+          <th scope="col">Errors</th>
+          <th scope="col" data-sortable="true">Response (ms)</th>
+          <th scope="col" data-sortable="true">History Lag (ms)</th>
+          <th scope="col">Last Action Block</th>
+          <th scope="col">Get Transaction</th>
+          <th scope="col">Test Origin</th>
+        </tr>
+        </thead>
+        <tbody>
+        {{range .History}}
+        <tr id="{{.Node}}/v1/history">
+          <th scope="row"><a href="{{.PageUrl}}">{{.Node}}</a></th>
+          <td>{{template "grade" .}}</td>
+          <td>{{if .HadError}}<img src="{{asset "tri.svg"}}" alt="failed" width="28" height="28">{{else}}<img src="{{asset "check.svg"}}" alt="ok" width="28" height="28">{{end}}</td>
           <td class="text-info" style="max-width: 250px;"><div class="d-inline-block overflow-hidden" style="max-width: 245px;max-height: 40px;">
-          <span href="#" data-toggle="tooltip" delay="0" title="{{.ErrMsg}}">
-              {{.ErrMsg}}
+          <span data-toggle="tooltip" delay="0" title="{{.Error}}">
+              {{.Error}}
           </span>
           </div></td>
-          <td>{{if .Healthy}}{{.HeadBlockLatency}}{{end}}</td>
+          <td {{if .Slow}}class="text-warning"{{end}}>{{.RequestLatency}}</td>
+          <td>{{.HistoryLag}}</td>
+          <td>{{if .LastActionBlock}}{{.LastActionBlock}}{{end}}</td>
+          <td>{{if .TransactionOk}}<img src="{{asset "check.svg"}}" alt="ok" width="28" height="28">{{else}}<img src="{{asset "tri.svg"}}" alt="failed" width="28" height="28">{{end}}</td>
           <td>{{.FromGeo}}</td>
         </tr>
         {{end}}
         </tbody>
       </table>
+    {{end}}
+    {{with .Diversity}}
+    <br />
+    <h2>Network Diversity</h2>
+    {{range .Warnings}}<div class="alert alert-warning" role="alert">{{.}}</div>{{end}}
+    <div class="row">
+      <div class="col-md">
+      <table class="table table-striped table-sm table-hover table-borderless">
+        <thead class="thead-dark">
+        <tr>
+          <th scope="col">Country</th>
+          <th scope="col">API Nodes</th>
+          <th scope="col">P2P Nodes</th>
+        </tr>
+        </thead>
+        <tbody>
+        {{range .Countries}}
+        <tr>
+          <th scope="row">{{.Name}}</th>
+          <td>{{.Api}}</td>
+          <td>{{.P2p}}</td>
+        </tr>
+        {{end}}
+        </tbody>
+      </table>
+      </div>
+      {{if .Providers}}
+      <div class="col-md">
+      <table class="table table-striped table-sm table-hover table-borderless">
+        <thead class="thead-dark">
+        <tr>
+          <th scope="col">Provider</th>
+          <th scope="col">API Nodes</th>
+          <th scope="col">P2P Nodes</th>
+        </tr>
+        </thead>
+        <tbody>
+        {{range .Providers}}
+        <tr>
+          <th scope="row">{{.Name}}</th>
+          <td>{{.Api}}</td>
+          <td>{{.P2p}}</td>
+        </tr>
+        {{end}}
+        </tbody>
+      </table>
+      </div>
+      {{end}}
+    </div>
+    {{end}}
   </div>
   </div>
   <script>
@@ -195,6 +264,31 @@ const Report = `<!DOCTYPE html>
         removeButtons();
       }
     };
+    const showTls = async function(fileName, node) {
+      let response = await fetch("tls/" + fileName + ".json");
+      if (!response.ok) {
+        return
+      }
+      let matrix = await response.json();
+      let content = '<table class="table table-sm table-borderless"><thead><tr><th>Version</th><th>Cipher Suites</th></tr></thead><tbody>';
+      for (const v of matrix.versions) {
+        let suites = "";
+        for (const s of v.suites) {
+          if (v.weak_suites.includes(s)) {
+            suites += '<span class="text-warning">' + s + "</span><br />";
+          } else {
+            suites += s + "<br />";
+          }
+        }
+        content += "<tr><td>" + v.version + "</td><td>" + (v.supported ? suites : "not supported") + "</td></tr>";
+      }
+      content += "</tbody></table>";
+      content += "<p>Key exchange groups: " + matrix.groups.join(", ") + "</p>";
+      content += "<p>ALPN: " + matrix.alpn.join(", ") + "</p>";
+      document.getElementById("tlsModalLabel").textContent = "TLS Details - " + node;
+      document.getElementById("tlsDetails").innerHTML = content;
+      $("#tlsModal").modal("show");
+    };
     window.onload = function() {
       previous()
     };
@@ -219,14 +313,91 @@ const Report = `<!DOCTYPE html>
     );
     }
   </script>
-  <script src="https://code.jquery.com/jquery-3.5.1.slim.min.js" integrity="sha384-DfXdz2htPH0lsSSs5nCTpuj/zy4C+OGpamoFVy38MVBnE+IbbVYUew+OrCXaRkfj" crossorigin="anonymous"></script>
-  <script src="https://cdn.jsdelivr.net/npm/popper.js@1.16.1/dist/umd/popper.min.js" integrity="sha384-9/reFTGAW83EW2RDu2S0VKaIzap3H66lZH81PoYlFhbGU+6BZp6G7niu735Sk7lN" crossorigin="anonymous"></script>
-  <script src="https://stackpath.bootstrapcdn.com/bootstrap/4.5.2/js/bootstrap.min.js" integrity="sha384-B4gt1jrGC7Jh4AgTPSdUtOBvfO8shuf57BaghqFfPlYxofvL8/KUEfYiJOMMV+rV" crossorigin="anonymous"></script>
-  <script src="https://unpkg.com/bootstrap-table@1.18.0/dist/bootstrap-table.min.js"></script>
-  <script src="chartv2.js"></script>
+  <script src="{{asset "jquery.slim.min.js"}}" {{sri "jquery.slim.min.js"}}></script>
+  <script src="{{asset "popper.min.js"}}" {{sri "popper.min.js"}}></script>
+  <script src="{{asset "bootstrap.min.js"}}" {{sri "bootstrap.min.js"}}></script>
+  <script src="{{asset "bootstrap-table.min.js"}}" {{sri "bootstrap-table.min.js"}}></script>
+  <script src="{{asset "chartv2.js"}}"></script>
 
   <script>
   
   </script>
 </body>
-</html>`
+</html>
+{{define "apiRow"}}
+        <tr id="{{.Node}}{{if .Ip}} {{.Ip}}{{end}}">
+          <th scope="row" class="align-middle"><a href="{{.PageUrl}}">{{.Node}}</a>{{if .Ip}}<br /><small class="text-muted">{{.Ip}}</small>{{end}}</th>
+          <td class="align-middle">{{template "grade" .}}</td>
+          <th scope="row" {{if .WrongVersion}}class="align-middle text-warning"{{else}}class="align-middle"{{end}}>{{.NodeVer}}</th>
+          <td class="align-middle">{{if .HadError}}<img src="{{asset "tri.svg"}}" alt="failed" width="28" height="28">{{else}}<img src="{{asset "check.svg"}}" alt="ok" width="28" height="28">{{end}}</td>
+          <td class="text-info" style="max-width: 250px;"><div class="d-inline-block overflow-hidden" style="max-width: 245px;max-height: 40px;" >
+          <span data-toggle="tooltip" delay="0" trigger="hover focus" placement="right" title="{{.Error}}">
+              {{.Error}}
+          </span>
+          </div></td>
+          <td class="align-middle">
+            <div>
+              <button type="button" class="chart-button btn btn-outline-dark" onClick="graphLatency('{{.Node}}')">
+                <img src="{{asset "chart.svg"}}" alt="view latency chart" class="align-middle" width="20" height="20">
+              </button>
+             </div>
+          </td>
+          <td {{if .Slow}}class="align-middle text-warning"{{else}}class="align-middle"{{end}}>
+            <div>
+			  {{.RequestLatency}} 
+             </div>
+           </td>
+          <td class="align-middle">
+            <div>
+              <button type="button" class="chart-button btn btn-outline-dark" onClick="graphLatency('{{.Node}}', 'lag')">
+                <img src="{{asset "chart.svg"}}" alt="view latency chart" class="align-middle" width="20" height="20">
+              </button>
+             </div>
+          </td>
+          <td {{if .Lagging}} class="text-warning align-middle"{{else}} class="align-middle"{{end}}>
+              {{.HeadBlockLatency}}
+             </div>
+          </td>
+          <td class="align-middle">{{if .PermissiveCors}}<img src="{{asset "check.svg"}}" alt="ok" width="28" height="28">{{else}}<img src="{{asset "tri.svg"}}" alt="failed" width="28" height="28">{{end}}</td>
+          <td class="align-middle">{{if .Headers}}{{with .HeaderWarnings}}<span data-toggle="tooltip" delay="0" title="{{range $i, $e := .}}{{if $i}}, {{end}}{{$e}}{{end}}"><img src="{{asset "tri.svg"}}" alt="failed" width="28" height="28"></span>{{else}}<img src="{{asset "check.svg"}}" alt="ok" width="28" height="28">{{end}}{{end}}</td>
+          <td class="align-middle">{{ if not .TlsVerOk}}<img src="{{asset "slash.svg"}}" alt="failed" width="28" height="28">{{else if not .TlsCipherOk}}<img src="{{asset "slash.svg"}}" alt="failed" width="28" height="28">{{else}}<img src="{{asset "check.svg"}}" alt="ok" width="28" height="28">{{end}}
+            {{if .TlsMatrix}}<button type="button" class="chart-button btn btn-sm btn-outline-dark" onClick="showTls('{{.FileName}}', '{{.Node}}')">details</button>{{end}}
+          </td>
+          <td class="align-middle" style="max-width: 250px;"><div class="d-inline-block overflow-hidden" style="max-width: 245px;max-height: 40px;">
+          <span data-toggle="tooltip" delay="0" trigger="hover focus" placement="right" title="{{.TlsNote}}">
+              {{.TlsNote}}
+          </span>
+          </div></td>
+          <td class="align-middle">{{with .Cert}}{{if .Ok}}<img src="{{asset "check.svg"}}" alt="ok" width="28" height="28">{{else}}<span data-toggle="tooltip" delay="0" title="{{range $i, $e := .Notes}}{{if $i}}, {{end}}{{$e}}{{end}}"><img src="{{asset "slash.svg"}}" alt="failed" width="28" height="28"></span>{{end}}{{end}}</td>
+          <td class="text-center align-middle">{{with .Exposed}}<span data-toggle="tooltip" delay="0" title="{{range $i, $e := .}}{{if $i}}, {{end}}{{$e}}{{end}}"><img src="{{asset "exc.svg"}}" alt="failed" width="28" height="28"></span>{{end}}</td>
+          <td class="align-middle text-nowrap">{{if .Ipv4}}{{with .Ipv4}}<span data-toggle="tooltip" delay="0" title="{{if .Published}}{{.Address}}{{if .Error}}: {{.Error}}{{end}}{{else}}no A records{{end}}" class="badge {{if .Reachable}}badge-success{{else if .Published}}badge-warning{{else}}badge-secondary{{end}}">v4</span>{{end}}
+            {{with .Ipv6}}<span data-toggle="tooltip" delay="0" title="{{if .Published}}{{.Address}}{{if .Error}}: {{.Error}}{{end}}{{else}}no AAAA records{{end}}" class="badge {{if .Reachable}}badge-success{{else if .Published}}badge-warning{{else}}badge-secondary{{end}}">v6</span>{{end}}{{end}}</td>
+          <td class="align-middle">{{with .Dns}}<span data-toggle="tooltip" delay="0" title="{{.Status}}, {{.ResolveTime}} ms, ttl {{.MinTtl}}{{range .Notes}}, {{.}}{{end}}{{range .Info}}, {{.}}{{end}}">{{if .Ok}}<img src="{{asset "check.svg"}}" alt="ok" width="28" height="28">{{else if eq .Status "ok"}}<img src="{{asset "exc.svg"}}" alt="warning" width="28" height="28">{{else}}<img src="{{asset "tri.svg"}}" alt="failed" width="28" height="28">{{end}}</span>{{end}}</td>
+          <td>{{.FromGeo}}</td>
+        </tr>
+{{end}}
+{{define "p2pRow"}}
+        <tr id="{{.Peer}}{{if .Ip}} {{.Ip}}{{end}}">
+          <th scope="row"><a href="{{.PageUrl}}">{{.Peer}}</a>{{if .Ip}}<br /><small class="text-muted">{{.Ip}}</small>{{end}}</th>
+          <td>{{template "grade" .}}</td>
+          <td>{{with .Handshake}}<span data-toggle="tooltip" delay="0" title="{{.Os}} head: {{.HeadNum}} lib: {{.LibNum}}">{{.Agent}}</span>{{end}}</td>
+          <td {{with .Handshake}}{{if .WrongChain}}class="text-warning"{{end}}{{end}}>{{with .Handshake}}{{.NetworkVersion}}{{end}}</td>
+          <td>{{if .Reachable}}<img src="{{asset "check.svg"}}" alt="ok" width="28" height="28">{{else}}<img src="{{asset "tri.svg"}}" alt="failed" width="28" height="28">{{end}}</td>
+          <td>{{if .Healthy}}<img src="{{asset "check.svg"}}" alt="ok" width="28" height="28">{{else}}<img src="{{asset "tri.svg"}}" alt="failed" width="28" height="28">{{end}}</td>
+          <td class="text-info" style="max-width: 250px;"><div class="d-inline-block overflow-hidden" style="max-width: 245px;max-height: 40px;">
+          <span href="#" data-toggle="tooltip" delay="0" title="{{.ErrMsg}}">
+              {{if and .Status (ne .Status "ok")}}<span class="badge {{if .Status.Alarm}}badge-warning{{else}}badge-secondary{{end}}">{{.Status}}</span>{{end}}
+              {{.ErrMsg}}
+          </span>
+          </div></td>
+          <td>{{if .Healthy}}{{.HeadBlockLatency}}{{end}}</td>
+          <td>{{with .Stream}}<span data-toggle="tooltip" delay="0" title="{{.Blocks}} blocks in {{.Window}}s, jitter {{printf "%.0f" .Jitter}} ms, {{.Missing}} missing, {{.Duplicates}} duplicate">{{printf "%.2f" .BlocksPerSec}}</span>{{end}}</td>
+          <td>{{with .Stream}}{{.MaxGap}}{{end}}</td>
+          <td>{{with .Sync}}<span data-toggle="tooltip" delay="0" title="{{.Received}} of {{.Requested}} blocks from {{.StartBlock}}{{if .Error}}: {{.Error}}{{end}}" {{if not .Complete}}class="text-warning"{{end}}>{{printf "%.1f" .BlocksPerSec}}</span>{{end}}</td>
+          <td class="text-nowrap">{{if .Ipv4}}{{with .Ipv4}}<span data-toggle="tooltip" delay="0" title="{{if .Published}}{{.Address}}{{if .Error}}: {{.Error}}{{end}}{{else}}no A records{{end}}" class="badge {{if .Reachable}}badge-success{{else if .Published}}badge-warning{{else}}badge-secondary{{end}}">v4</span>{{end}}
+            {{with .Ipv6}}<span data-toggle="tooltip" delay="0" title="{{if .Published}}{{.Address}}{{if .Error}}: {{.Error}}{{end}}{{else}}no AAAA records{{end}}" class="badge {{if .Reachable}}badge-success{{else if .Published}}badge-warning{{else}}badge-secondary{{end}}">v6</span>{{end}}{{end}}</td>
+          <td>{{with .Dns}}<span data-toggle="tooltip" delay="0" title="{{.Status}}, {{.ResolveTime}} ms, ttl {{.MinTtl}}{{range .Notes}}, {{.}}{{end}}{{range .Info}}, {{.}}{{end}}">{{if .Ok}}<img src="{{asset "check.svg"}}" alt="ok" width="28" height="28">{{else if eq .Status "ok"}}<img src="{{asset "exc.svg"}}" alt="warning" width="28" height="28">{{else}}<img src="{{asset "tri.svg"}}" alt="failed" width="28" height="28">{{end}}</span>{{end}}</td>
+          <td>{{.FromGeo}}</td>
+        </tr>
+{{end}}
+{{define "grade"}}{{if .Grade}}<span data-toggle="tooltip" delay="0" title="health {{.Health}}/100 over recent checks{{range .Breakdown}}, {{.Detail}} (+{{printf "%.1f" .Points}}){{end}}" class="badge {{if eq .Grade "A"}}badge-success{{else if eq .Grade "B"}}badge-info{{else if eq .Grade "C" "D"}}badge-warning{{else}}badge-danger{{end}}">{{.Grade}}</span><span class="d-none">{{.Health}}</span>{{end}}{{end}}`
@@ -0,0 +1,100 @@
+package fhassets
+
+import (
+	"bytes"
+	"errors"
+	fiohealth "github.com/fioprotocol/health"
+	"html/template"
+	"log"
+)
+
+// files in a custom template directory, each is optional
+const (
+	ReportTemplate   = "report.html" // executed with *fiohealth.ReportData
+	NodePageTemplate = "node.html"   // executed with *fiohealth.NodePage, asset urls are relative to nodes/
+	AssetDir         = "assets"      // files here replace the built-in asset with the same name, or add a new one
+)
+
+// Templates renders the report and node pages. When a custom template directory is configured, anything it provides
+// is used instead of the built-in templates and assets, and the built-in version is used for anything else.
+type Templates struct {
+	Assets *Assets
+	report *template.Template // nil unless a custom template was loaded
+	node   *template.Template
+}
+
+// LoadTemplates gets the templates and assets for the config. Unless cdn_assets is set, an error is returned if any
+// of the embedded libraries are missing. Problems with the custom template directory are logged, and the built-in
+// templates are used instead.
+func LoadTemplates(conf *fiohealth.Config) (*Templates, error) {
+	if !conf.CdnAssets {
+		if err := checkVendored(); err != nil {
+			return nil, errors.New(err.Error() + " (run make vendor, or set cdn_assets)")
+		}
+	}
+	t := &Templates{Assets: NewAssets(conf.DarkTheme, conf.CdnAssets)}
+	if conf.TemplateDir == "" {
+		return t, nil
+	}
+	store := newStore(conf.TemplateDir, conf.Region)
+
+	// assets are loaded first, so the templates can refer to new ones
+	names, err := store.list(AssetDir)
+	if err != nil {
+		conf.Log("no custom assets: " + err.Error())
+	}
+	for _, name := range names {
+		b, err := store.read(AssetDir + "/" + name)
+		if err != nil {
+			log.Println("could not read custom asset " + name + ": " + err.Error())
+			continue
+		}
+		t.Assets.files[name] = &Asset{Name: name, Content: string(b)}
+	}
+
+	load := func(file string, base string) *template.Template {
+		b, err := store.read(file)
+		if err != nil || len(b) == 0 {
+			conf.Log("no custom " + file + ", using the built-in template")
+			return nil
+		}
+		tmpl, err := template.New(file).Funcs(t.Assets.Funcs(base)).Parse(string(b))
+		if err != nil {
+			log.Println("could not parse custom " + file + ", using the built-in template: " + err.Error())
+			return nil
+		}
+		return tmpl
+	}
+	t.report = load(ReportTemplate, "")
+	t.node = load(NodePageTemplate, "../")
+	return t, nil
+}
+
+// Report renders the report
+func (t *Templates) Report(data *fiohealth.ReportData) ([]byte, error) {
+	return t.render(t.report, ReportTemplate, Report, "", data)
+}
+
+// NodePage renders a node's detail page
+func (t *Templates) NodePage(page *fiohealth.NodePage) ([]byte, error) {
+	return t.render(t.node, NodePageTemplate, NodePage, "../", page)
+}
+
+// render executes the custom template if there is one, falling back to the built-in template if it fails
+func (t *Templates) render(custom *template.Template, name string, builtin string, base string, data interface{}) ([]byte, error) {
+	out := bytes.NewBuffer(nil)
+	if custom != nil {
+		err := custom.Execute(out, data)
+		if err == nil {
+			return out.Bytes(), nil
+		}
+		log.Println("custom " + name + " failed, using the built-in template: " + err.Error())
+		out.Reset()
+	}
+	tmpl, err := template.New(name).Funcs(t.Assets.Funcs(base)).Parse(builtin)
+	if err != nil {
+		return nil, err
+	}
+	err = tmpl.Execute(out, data)
+	return out.Bytes(), err
+}
@@ -0,0 +1,75 @@
+package fhassets
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+//go:generate go run gen-vendor.go
+
+// vendorAsset is a third-party library used by the report. A copy is embedded (see vendor-content.go) and written
+// alongside the report unless CDN mode is enabled, in which case it is loaded from Cdn.
+type vendorAsset struct {
+	name      string
+	cdn       string
+	integrity string // subresource integrity of the CDN copy, the embedded copy must match it
+}
+
+// vendorAssets are the pinned third-party libraries, gen-vendor.go downloads these into vendor-content.go
+var vendorAssets = []vendorAsset{
+	{
+		name:      "jquery.slim.min.js",
+		cdn:       "https://code.jquery.com/jquery-3.5.1.slim.min.js",
+		integrity: "sha384-DfXdz2htPH0lsSSs5nCTpuj/zy4C+OGpamoFVy38MVBnE+IbbVYUew+OrCXaRkfj",
+	},
+	{
+		name:      "popper.min.js",
+		cdn:       "https://cdn.jsdelivr.net/npm/popper.js@1.16.1/dist/umd/popper.min.js",
+		integrity: "sha384-9/reFTGAW83EW2RDu2S0VKaIzap3H66lZH81PoYlFhbGU+6BZp6G7niu735Sk7lN",
+	},
+	{
+		name:      "bootstrap.min.js",
+		cdn:       "https://stackpath.bootstrapcdn.com/bootstrap/4.5.2/js/bootstrap.min.js",
+		integrity: "sha384-B4gt1jrGC7Jh4AgTPSdUtOBvfO8shuf57BaghqFfPlYxofvL8/KUEfYiJOMMV+rV",
+	},
+	{
+		name: "bootstrap-table.min.js",
+		cdn:  "https://unpkg.com/bootstrap-table@1.18.0/dist/bootstrap-table.min.js",
+	},
+	{
+		name: "bootstrap-table.min.css",
+		cdn:  "https://unpkg.com/bootstrap-table@1.18.0/dist/bootstrap-table.min.css",
+	},
+	{
+		name: "echarts.min.js",
+		cdn:  "https://cdn.jsdelivr.net/npm/echarts@4.9.0/dist/echarts.min.js",
+	},
+}
+
+// sri gives the subresource integrity value for content
+func sri(content string) string {
+	sum := sha512.Sum384([]byte(content))
+	return "sha384-" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// checkVendored returns an error listing the libraries that don't have an embedded copy matching their pinned
+// integrity, in which case vendor-content.go needs to be generated.
+func checkVendored() error {
+	problems := make([]string, 0)
+	for _, v := range vendorAssets {
+		switch content := vendored[v.name]; {
+		case content == "":
+			problems = append(problems, v.name+" is missing")
+		case v.integrity == "":
+			problems = append(problems, v.name+" has no pinned integrity")
+		case sri(content) != v.integrity:
+			problems = append(problems, v.name+" does not match its integrity")
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New("embedded libraries: " + strings.Join(problems, ", "))
+	}
+	return nil
+}
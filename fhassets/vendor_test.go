@@ -0,0 +1,26 @@
+package fhassets
+
+import "testing"
+
+func TestVendored(t *testing.T) {
+	for _, v := range vendorAssets {
+		content, ok := vendored[v.name]
+		switch {
+		case !ok || content == "":
+			t.Errorf("%s is missing from vendor-content.go, run make vendor", v.name)
+		case v.integrity == "":
+			t.Errorf("%s has no pinned integrity in vendor.go", v.name)
+		case sri(content) != v.integrity:
+			t.Errorf("%s does not match its integrity: expected %s, got %s", v.name, v.integrity, sri(content))
+		}
+	}
+	for name := range vendored {
+		found := false
+		for _, v := range vendorAssets {
+			found = found || v.name == name
+		}
+		if !found {
+			t.Errorf("%s is in vendor-content.go but not vendor.go", name)
+		}
+	}
+}
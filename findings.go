@@ -0,0 +1,43 @@
+package fiohealth
+
+// Finding categories, security findings are alerted separately from health findings for API nodes
+const (
+	CategoryHealth   = "health"
+	CategorySecurity = "security"
+)
+
+// Finding severities
+const (
+	SeverityCritical = "critical"
+	SeverityWarning  = "warning"
+	SeverityInfo     = "info"
+)
+
+// Finding is a single problem found by a check. Observed and Threshold are set when the check compares a value
+// against a limit.
+type Finding struct {
+	Check     string `json:"check"`
+	Category  string `json:"category"`
+	Severity  string `json:"severity"`
+	Message   string `json:"message"`
+	Observed  string `json:"observed,omitempty"`
+	Threshold string `json:"threshold,omitempty"`
+}
+
+// Add records a finding, and adds points to the score for it. The finding is returned so it can be passed on to
+// the alerts.
+func (s *Scorecard) Add(f *Finding, points float32) *Finding {
+	s.Findings = append(s.Findings, f)
+	s.AddScore(f.Check, points, f.Message)
+	return f
+}
+
+// sameFinding is used to avoid repeating a finding in the alert state
+func sameFinding(findings []*Finding, f *Finding) bool {
+	for _, existing := range findings {
+		if existing.Check == f.Check && existing.Message == f.Message {
+			return true
+		}
+	}
+	return false
+}
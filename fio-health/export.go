@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	fiohealth "github.com/fioprotocol/health"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// export writes csv and parquet files of the saved API and p2p results over a date range, run with: fio-health export
+func export(args []string) error {
+	var source, region, fromDate, toDate, out, formats string
+	week := time.Now().UTC().AddDate(0, 0, -6)
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	fs.StringVar(&source, "source", ".", "output_dir of the health checks, a local directory or s3 url")
+	fs.StringVar(&region, "region", "us-east-1", "region of the s3 bucket")
+	fs.StringVar(&fromDate, "from", week.Format("2006-01-02"), "first day to export (UTC)")
+	fs.StringVar(&toDate, "to", time.Now().UTC().Format("2006-01-02"), "last day to export (UTC)")
+	fs.StringVar(&out, "out", ".", "directory to write the files to")
+	fs.StringVar(&formats, "format", "csv,parquet", "csv, parquet, or both")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	from, err := time.Parse("2006-01-02", fromDate)
+	if err != nil {
+		return errors.New("invalid from date: " + err.Error())
+	}
+	to, err := time.Parse("2006-01-02", toDate)
+	if err != nil {
+		return errors.New("invalid to date: " + err.Error())
+	}
+	if to.Before(from) {
+		return errors.New("the to date is before the from date")
+	}
+	e, err := fiohealth.NewExport(source, region, from, to.AddDate(0, 0, 1))
+	if err != nil {
+		return err
+	}
+	log.Printf("exporting %d runs: %d api and %d p2p results\n", e.Runs, len(e.Api), len(e.P2p))
+
+	err = os.MkdirAll(out, 0755)
+	if err != nil {
+		return err
+	}
+	suffix := "_" + fromDate + "_" + toDate
+	for _, format := range strings.Split(formats, ",") {
+		var write func(w io.Writer, rows interface{}) error
+		switch strings.TrimSpace(format) {
+		case "csv":
+			write = fiohealth.WriteCsv
+		case "parquet":
+			write = fiohealth.WriteParquet
+		default:
+			return errors.New("unknown format " + format + ", should be csv or parquet")
+		}
+		for name, rows := range map[string]interface{}{"api": e.Api, "p2p": e.P2p} {
+			file := out + "/" + name + suffix + "." + strings.TrimSpace(format)
+			b := bytes.NewBuffer(nil)
+			err = write(b, rows)
+			if err != nil {
+				return errors.New(file + ": " + err.Error())
+			}
+			err = ioutil.WriteFile(file, b.Bytes(), 0644)
+			if err != nil {
+				return err
+			}
+			log.Println("wrote " + file)
+		}
+	}
+	return nil
+}
@@ -1,12 +1,10 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
 	"github.com/aws/aws-lambda-go/lambda"
 	fiohealth "github.com/fioprotocol/health"
 	"github.com/fioprotocol/health/fhassets"
-	"html/template"
 	"io/ioutil"
 	"log"
 	"os"
@@ -18,6 +16,12 @@ import (
 
 func main() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		if err := export(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	if os.Getenv("LAMBDA_TASK_ROOT") != "" {
 		lambda.Start(handler)
 		return
@@ -25,28 +29,34 @@ func main() {
 	log.Println(handler())
 }
 
-type History struct {
-	File string `json:"file"`
-	Date string `json:"date"`
-	From string `json:"from"`
-
-	sort int64
-}
-
 func handler() error {
 	conf, err := fiohealth.GetConfig()
 	if err != nil {
 		return err
 	}
-	tmpl := template.New("Report")
-	tmpl = template.Must(tmpl.Parse(fhassets.Report))
+	templates, err := fhassets.LoadTemplates(conf)
+	if err != nil {
+		return err
+	}
+	assets := templates.Assets
 	final := fiohealth.FinalResult{
-		Api:         fiohealth.CheckApis(conf),
-		P2p:         fiohealth.CheckP2p(conf),
-		Timestamp:   time.Now().UTC().Format(time.UnixDate),
-		Description: conf.ReportTitle,
+		SchemaVersion: fiohealth.SchemaV1,
+		Api:           fiohealth.CheckApis(conf),
+		P2p:           fiohealth.CheckP2p(conf),
+		History:       fiohealth.CheckHistory(conf),
+		Timestamp:     time.Now().UTC().Format(time.UnixDate),
+		Description:   conf.ReportTitle,
+	}
+	err = fiohealth.LocateNodes(&final, conf)
+	if err != nil {
+		log.Println("could not locate nodes: " + err.Error())
+	}
+	grades := fiohealth.LoadGrades(conf)
+	grades.Apply(&final, conf)
+	err = grades.Save(conf)
+	if err != nil {
+		log.Println("could not save grade history: " + err.Error())
 	}
-	out := bytes.NewBuffer(nil)
 
 	sort.Slice(final.P2p, func(i, j int) bool {
 		if final.P2p[i].Score == final.P2p[j].Score {
@@ -61,19 +71,20 @@ func handler() error {
 		}
 		return final.Api[i].Score > final.Api[j].Score
 	})
-	err = tmpl.Execute(out, final)
-	if err != nil {
-		log.Println("template error:" + err.Error())
-	}
-	html := out.Bytes()
+	sort.Slice(final.History, func(i, j int) bool {
+		if final.History[i].Score == final.History[j].Score {
+			return final.History[i].HistoryLag > final.History[j].HistoryLag
+		}
+		return final.History[i].Score > final.History[j].Score
+	})
 
 	now := time.Now().UTC()
 	nowStr := strconv.FormatInt(now.UTC().Unix(), 10)
 	nowFormat := now.Format(time.UnixDate)
 	nowInt := now.Unix()
-	geo, _ := fiohealth.MyGeo(conf.Geolite)
+	geo := conf.TestOrigin()
 	jIndex := make([]string, 0)
-	hIndex := make([]History, 0)
+	hIndex := make([]fiohealth.HistoryEntry, 0)
 
 	mkJson := func(payload interface{}) []byte {
 		j := make([]byte, 0)
@@ -87,19 +98,19 @@ func handler() error {
 				payload = payload.([]string)[:72]
 			}
 			sort.Strings(payload.([]string))
-		case []History:
-			payload = append(payload.([]History), History{
+		case []fiohealth.HistoryEntry:
+			payload = append(payload.([]fiohealth.HistoryEntry), fiohealth.HistoryEntry{
 				File: nowStr + ".html",
 				Date: nowFormat,
 				From: geo,
-				sort: nowInt,
+				Sort: nowInt,
 			})
-			sort.Slice(payload.([]History), func(i, j int) bool {
-				return payload.([]History)[i].sort > payload.([]History)[j].sort
+			sort.Slice(payload.([]fiohealth.HistoryEntry), func(i, j int) bool {
+				return payload.([]fiohealth.HistoryEntry)[i].Sort > payload.([]fiohealth.HistoryEntry)[j].Sort
 			})
 			// truncate html history
-			if len(payload.([]History)) > 96 {
-				payload = payload.([]History)[:96]
+			if len(payload.([]fiohealth.HistoryEntry)) > 96 {
+				payload = payload.([]fiohealth.HistoryEntry)[:96]
 			}
 		}
 		j, _ = json.MarshalIndent(payload, "", "  ")
@@ -124,7 +135,33 @@ func handler() error {
 		}
 	}
 
+	// TLS details are only used by the detail view, so they are stored per node instead of in the report
+	for _, node := range final.Api {
+		for _, check := range append([]*fiohealth.Result{node}, node.Addresses...) {
+			if check.TlsMatrix == nil {
+				continue
+			}
+			j, _ := json.MarshalIndent(check.TlsMatrix, "", "  ")
+			err = conf.WriteOutput("tls/"+check.FileName()+".json", j)
+			if err != nil {
+				log.Println("could not write tls details: " + err.Error())
+			}
+		}
+	}
+
 	combined := make([]fiohealth.FinalResult, 0)
+	var (
+		data *fiohealth.ReportData
+		html []byte
+	)
+	// the report is rendered once the recent runs are loaded, they are used for uptime
+	render := func() {
+		data = fiohealth.NewReportData(conf, final, combined)
+		html, err = templates.Report(data)
+		if err != nil {
+			log.Println("template error:" + err.Error())
+		}
+	}
 	switch strings.HasPrefix(conf.OutputDir, "s3://") {
 	case true:
 		// get existing index, or create a new one
@@ -144,7 +181,7 @@ func handler() error {
 			default:
 				err = json.Unmarshal(fTemp, &hIndex)
 				if err != nil {
-					hIndex = make([]History, 0)
+					hIndex = make([]fiohealth.HistoryEntry, 0)
 				}
 				j = mkJson(hIndex)
 			}
@@ -156,6 +193,7 @@ func handler() error {
 		}
 		index("json", "json")
 		index("history", "html")
+		render()
 
 		var j []byte
 		j, err = json.MarshalIndent(final, "", "  ")
@@ -177,6 +215,11 @@ func handler() error {
 					log.Printf("healthy p2p result with error message detected: %+v\n", check)
 				}
 			}
+			for _, check := range final.History {
+				if !check.HadError && check.Error != "" {
+					log.Printf("healthy history result with error message detected: %+v\n", check)
+				}
+			}
 		}
 
 		j, err = json.MarshalIndent(combined, "", "  ")
@@ -196,11 +239,11 @@ func handler() error {
 		if err != nil {
 			return err
 		}
-		err = fhassets.WriteS3Assets(conf.DarkTheme, conf.Bucket, conf.Prefix, conf.Region)
+		err = fhassets.WriteS3Assets(assets, conf.Bucket, conf.Prefix, conf.Region)
 		if err != nil {
 			return err
 		}
-		err = fhassets.WriteS3Assets(conf.DarkTheme, conf.Bucket, conf.Prefix+"/history", conf.Region)
+		err = fhassets.WriteS3Assets(assets, conf.Bucket, conf.Prefix+"/history", conf.Region)
 		if err != nil {
 			return err
 		}
@@ -262,6 +305,7 @@ func handler() error {
 		if err != nil {
 			return err
 		}
+		render()
 
 		f := &os.File{}
 		var j []byte
@@ -341,11 +385,68 @@ func handler() error {
 		}
 		f.Close()
 
-		err = fhassets.WriteLocalAssets(conf.DarkTheme, conf.OutputDir)
+		err = fhassets.WriteLocalAssets(assets, conf.OutputDir)
+		if err != nil {
+			return err
+		}
+		err = fhassets.WriteLocalAssets(assets, conf.OutputDir+"/history")
+		if err != nil {
+			return err
+		}
+	}
+
+	err = writeNodePages(conf, templates, data.Nodes)
+	if err != nil {
+		log.Println("could not write node pages: " + err.Error())
+	}
+	err = fiohealth.WriteFeed(conf, append(conf.ApiAlerts.Events(), conf.P2pAlerts.Events()...), nowStr+".html", now)
+	if err != nil {
+		log.Println("could not write alarm feed: " + err.Error())
+	}
+	err = writeBadges(conf, data)
+	if err != nil {
+		log.Println("could not write badges: " + err.Error())
+	}
+	if conf.JsonV2 {
+		err = fiohealth.WriteReportV2(conf, final, combined, nowStr+".json")
+		if err != nil {
+			log.Println("could not write v2 report: " + err.Error())
+		}
+	}
+	err = conf.WriteSchemas()
+	if err != nil {
+		log.Println("could not write json schemas: " + err.Error())
+	}
+	return nil
+}
+
+// writeNodePages writes the detail page and json for each node
+func writeNodePages(conf *fiohealth.Config, templates *fhassets.Templates, pages []*fiohealth.NodePage) error {
+	for _, page := range pages {
+		html, err := templates.NodePage(page)
+		if err != nil {
+			return err
+		}
+		err = conf.WriteOutput("nodes/"+page.FileName()+".html", html)
+		if err != nil {
+			return err
+		}
+		j, err := json.MarshalIndent(page, "", "  ")
+		if err != nil {
+			return err
+		}
+		err = conf.WriteOutput("nodes/"+page.FileName()+".json", j)
 		if err != nil {
 			return err
 		}
-		err = fhassets.WriteLocalAssets(conf.DarkTheme, conf.OutputDir+"/history")
+	}
+	return nil
+}
+
+// writeBadges writes the status badges for each node and the network
+func writeBadges(conf *fiohealth.Config, data *fiohealth.ReportData) error {
+	for name, svg := range data.Badges() {
+		err := conf.WriteOutput("badges/"+name, svg)
 		if err != nil {
 			return err
 		}
@@ -2,56 +2,286 @@ package fiohealth
 
 import (
 	"encoding/json"
-	"github.com/oschwald/maxminddb-golang"
 	"io/ioutil"
 	"log"
-	"net"
-	"net/http"
 	"os"
 	"sort"
 )
 
+// FinalResult is a single run in the v1 layout, this is what the report template and charts use.
 type FinalResult struct {
-	Api         []*Result    `json:"api"`
-	P2p         []*P2pResult `json:"p2p"`
-	Timestamp   string       `json:"timestamp"`
-	Description string       `json:"description"`
+	SchemaVersion int              `json:"schema_version"`
+	Api           []*Result        `json:"api"`
+	P2p           []*P2pResult     `json:"p2p"`
+	History       []*HistoryResult `json:"history,omitempty"`
+	Diversity     *DiversityReport `json:"diversity,omitempty"`
+	Timestamp     string           `json:"timestamp"`
+	Description   string           `json:"description"`
+}
+
+// Scorecard is embedded in each type of result, it holds every finding, and the score. Score is the total of the
+// Breakdown and is used for sorting. Health (0-100) and Grade are averaged over recent runs, and are only set for the
+// hostname, not for each address.
+type Scorecard struct {
+	Findings  []*Finding   `json:"findings,omitempty"`
+	Score     float32      `json:"score"`
+	Breakdown []*ScoreItem `json:"score_breakdown,omitempty"`
+	Health    int          `json:"health"`
+	Grade     string       `json:"grade,omitempty"`
+}
+
+// ScoreItem is a single finding that added to a node's score
+type ScoreItem struct {
+	Check  string  `json:"check"`
+	Points float32 `json:"points"`
+	Detail string  `json:"detail"`
 }
 
 // Result is the output from an API health check
 type Result struct {
-	Type             string  `json:"type"`
-	Node             string  `json:"node"`
-	NodeVer          string  `json:"node_ver"`
-	TimeStamp        int64   `json:"timestamp"`
-	HadError         bool    `json:"had_error"`
-	Error            string  `json:"error"`
-	ErrorFor         string  `json:"error_for"`
-	RequestLatency   int64   `json:"request_latency_ms"`
-	HeadBlockLatency int64   `json:"head_block_latency_ms"`
-	PermissiveCors   bool    `json:"permissive_cors"`
-	TlsVerOk         bool    `json:"tls_ver_ok"`
-	TlsCipherOk      bool    `json:"tls_cipher_ok"`
-	TlsNote          string  `json:"tls_note"`
-	ProducerExposed  bool    `json:"producer_exposed"`
-	NetExposed       bool    `json:"net_exposed"`
-	FromGeo          string  `json:"from_geo"`
-	Score            float32 `json:"-"`
-	WrongVersion     bool    `json:"-"`
+	Type             string             `json:"type"`
+	Node             string             `json:"node"`
+	Ip               string             `json:"ip,omitempty"`
+	NodeVer          string             `json:"node_ver"`
+	TimeStamp        int64              `json:"timestamp"`
+	HadError         bool               `json:"had_error"`
+	Error            string             `json:"error"`
+	ErrorFor         string             `json:"error_for"`
+	RequestLatency   int64              `json:"request_latency_ms"`
+	HeadBlockLatency int64              `json:"head_block_latency_ms"`
+	PermissiveCors   bool               `json:"permissive_cors"`
+	TlsVerOk         bool               `json:"tls_ver_ok"`
+	TlsCipherOk      bool               `json:"tls_cipher_ok"`
+	TlsNote          string             `json:"tls_note"`
+	Cert             *CertAudit         `json:"cert,omitempty"`
+	TlsMatrix        *TlsMatrix         `json:"-"`
+	Addresses        []*Result          `json:"addresses,omitempty"`
+	Ipv4             *FamilyResult      `json:"ipv4,omitempty"`
+	Ipv6             *FamilyResult      `json:"ipv6,omitempty"`
+	Dns              *DnsResult         `json:"dns,omitempty"`
+	Location         *NodeLocation      `json:"location,omitempty"`
+	Headers          []*HeaderFinding   `json:"headers"`
+	Security         []*SecurityFinding `json:"security"`
+	FromGeo          string             `json:"from_geo"`
+	Slow             bool               `json:"-"`
+	Lagging          bool               `json:"-"`
+	WrongVersion     bool               `json:"-"`
+	Scorecard
+}
+
+// SecurityFinding is the outcome of probing a single sensitive nodeos endpoint
+type SecurityFinding struct {
+	Name     string `json:"name"`
+	Endpoint string `json:"endpoint"`
+	Exposed  bool   `json:"exposed"`
+	Status   int    `json:"status"`
+}
+
+// TlsMatrix lists all of the TLS capabilities found for a node, it is large so is stored separately from the report
+type TlsMatrix struct {
+	Versions []*TlsVersionSupport `json:"versions"`
+	Groups   []string             `json:"groups"`
+	Alpn     []string             `json:"alpn"`
+}
+
+// TlsVersionSupport lists the cipher suites accepted for a single protocol version
+type TlsVersionSupport struct {
+	Version    string   `json:"version"`
+	Supported  bool     `json:"supported"`
+	Suites     []string `json:"suites"`
+	WeakSuites []string `json:"weak_suites"`
+}
+
+// CertAudit holds the results of validating the certificate chain for an API node
+type CertAudit struct {
+	ChainOk         bool        `json:"chain_ok"`
+	VerifyError     string      `json:"verify_error,omitempty"`
+	HostnameOk      bool        `json:"hostname_ok"`
+	IncompleteChain bool        `json:"incomplete_chain"`
+	KeyType         string      `json:"key_type"`
+	KeyBits         int         `json:"key_bits"`
+	WeakKey         bool        `json:"weak_key"`
+	WeakSignature   bool        `json:"weak_signature"`
+	OcspStapled     bool        `json:"ocsp_stapled"`
+	Revocation      string      `json:"revocation"`
+	Chain           []*CertInfo `json:"chain"`
+	Notes           []string    `json:"notes"`
+	Unchecked       bool        `json:"unchecked,omitempty"` // no certificate could be retrieved, nothing was validated
+}
+
+// CertInfo describes one certificate presented by the server, the leaf is first
+type CertInfo struct {
+	Subject            string `json:"subject"`
+	Issuer             string `json:"issuer"`
+	NotAfter           string `json:"not_after"`
+	DaysLeft           int64  `json:"days_left"`
+	SignatureAlgorithm string `json:"signature_algorithm"`
+}
+
+// HeaderFinding is the outcome of a single HTTP header, redirect, or CORS preflight check
+type HeaderFinding struct {
+	Name  string `json:"name"`
+	Ok    bool   `json:"ok"`
+	Value string `json:"value"`
+	Note  string `json:"note"`
+}
+
+// HeaderWarnings lists the failed header checks, used by the report template
+func (r *Result) HeaderWarnings() []string {
+	warnings := make([]string, 0)
+	for _, f := range r.Headers {
+		if !f.Ok {
+			warnings = append(warnings, f.Note)
+		}
+	}
+	return warnings
+}
+
+// FileName is the name used for any per-node output, without an extension
+func (r *Result) FileName() string {
+	if r.Ip != "" {
+		return NodeFileName(r.Node + "_" + r.Ip)
+	}
+	return NodeFileName(r.Node)
+}
+
+// Exposed lists the names of all sensitive endpoints that responded, used by the report template
+func (r *Result) Exposed() []string {
+	exposed := make([]string, 0)
+	for _, f := range r.Security {
+		if f.Exposed {
+			exposed = append(exposed, f.Name)
+		}
+	}
+	return exposed
 }
 
 // P2pResult is the output from a P2P health check
 type P2pResult struct {
-	Type             string `json:"type"`
-	Peer             string `json:"peer"`
-	TimeStamp        int64  `json:"time_stamp"`
-	Took             int64  `json:"took_sec"`
-	Reachable        bool   `json:"reachable"`
-	Healthy          bool   `json:"healthy"`
-	HeadBlockLatency int64  `json:"head_block_latency_ms"`
-	ErrMsg           string `json:"err_msg"`
-	FromGeo          string `json:"from_geo"`
-	Score            int    `json:"-"`
+	Type             string         `json:"type"`
+	Peer             string         `json:"peer"`
+	Ip               string         `json:"ip,omitempty"`
+	TimeStamp        int64          `json:"time_stamp"`
+	Took             int64          `json:"took_sec"`
+	TookMs           int64          `json:"took_ms,omitempty"`
+	Reachable        bool           `json:"reachable"`
+	Healthy          bool           `json:"healthy"`
+	Status           P2pStatus      `json:"status"`
+	HeadBlockLatency int64          `json:"head_block_latency_ms"`
+	ErrMsg           string         `json:"err_msg"`
+	Handshake        *PeerHandshake `json:"handshake,omitempty"`
+	Stream           *BlockStream   `json:"stream,omitempty"`
+	Sync             *SyncResult    `json:"sync,omitempty"`
+	Addresses        []*P2pResult   `json:"addresses,omitempty"`
+	Ipv4             *FamilyResult  `json:"ipv4,omitempty"`
+	Ipv6             *FamilyResult  `json:"ipv6,omitempty"`
+	Dns              *DnsResult     `json:"dns,omitempty"`
+	Location         *NodeLocation  `json:"location,omitempty"`
+	FromGeo          string         `json:"from_geo"`
+	Scorecard
+}
+
+// FamilyResult is the outcome of connecting to a node using only IPv4 or only IPv6
+type FamilyResult struct {
+	Published bool   `json:"published"`
+	Address   string `json:"address,omitempty"`
+	Reachable bool   `json:"reachable"`
+	Latency   int64  `json:"latency_ms,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// DnsResult describes the DNS health of a node's hostname
+type DnsResult struct {
+	Host        string   `json:"host"`
+	Status      string   `json:"status"` // ok, nxdomain, servfail, refused, no_records, timeout, or error
+	ResolveTime int64    `json:"resolve_ms"`
+	Addresses   []string `json:"addresses,omitempty"`
+	MinTtl      uint32   `json:"min_ttl"`
+	Nameservers []string `json:"nameservers,omitempty"`
+	NsMismatch  bool     `json:"ns_mismatch"`
+	Caa         []string `json:"caa,omitempty"`
+	Notes       []string `json:"notes,omitempty"`
+	Info        []string `json:"info,omitempty"` // informational, these don't affect Ok
+}
+
+// NodeLocation is the country and network a node's address belongs to, the ASN is only available if the GeoLite2
+// ASN database is present.
+type NodeLocation struct {
+	Ip          string `json:"ip"`
+	Country     string `json:"country,omitempty"`
+	CountryName string `json:"country_name,omitempty"`
+	Asn         uint   `json:"asn,omitempty"`
+	Provider    string `json:"provider,omitempty"`
+}
+
+// DiversityReport counts nodes per country and provider, with warnings if too many share a provider
+type DiversityReport struct {
+	Countries []*DiversityCount `json:"countries"`
+	Providers []*DiversityCount `json:"providers,omitempty"`
+	Warnings  []string          `json:"warnings,omitempty"`
+}
+
+// DiversityCount is the number of API and P2P nodes in a country or provider
+type DiversityCount struct {
+	Name string `json:"name"`
+	Api  int    `json:"api"`
+	P2p  int    `json:"p2p"`
+}
+
+// BlockStream describes the blocks received from a peer during the observation window
+type BlockStream struct {
+	Window       int     `json:"window_sec"`
+	Blocks       int     `json:"blocks"`
+	BlocksPerSec float64 `json:"blocks_per_sec"`
+	Jitter       float64 `json:"jitter_ms"`
+	MaxGap       int64   `json:"max_gap_ms"`
+	Missing      int     `json:"missing_blocks"`
+	Duplicates   int     `json:"duplicate_blocks"`
+}
+
+// SyncResult is the outcome of requesting a range of irreversible blocks from a peer, as a new node would
+type SyncResult struct {
+	StartBlock   uint32  `json:"start_block"`
+	Requested    int     `json:"requested"`
+	Received     int     `json:"received"`
+	Complete     bool    `json:"complete"`
+	BlocksPerSec float64 `json:"blocks_per_sec"`
+	Took         int64   `json:"took_ms"`
+	Error        string  `json:"error,omitempty"`
+}
+
+// PeerHandshake holds the interesting parts of the handshake message sent by a peer
+type PeerHandshake struct {
+	NodeId         string `json:"node_id"`
+	P2pAddress     string `json:"p2p_address"`
+	Agent          string `json:"agent"`
+	Os             string `json:"os"`
+	NetworkVersion uint16 `json:"network_version"`
+	ChainId        string `json:"chain_id"`
+	HeadNum        uint32 `json:"head_num"`
+	HeadId         string `json:"head_id"`
+	LibNum         uint32 `json:"lib_num"`
+	LibId          string `json:"lib_id"`
+	WrongChain     bool   `json:"wrong_chain"`
+	StaleLib       bool   `json:"stale_lib"`
+}
+
+// HistoryResult is the output from a v1 history API check
+type HistoryResult struct {
+	Type            string `json:"type"`
+	Node            string `json:"node"`
+	TimeStamp       int64  `json:"timestamp"`
+	HadError        bool   `json:"had_error"`
+	Error           string `json:"error"`
+	ErrorFor        string `json:"error_for"`
+	RequestLatency  int64  `json:"request_latency_ms"`
+	HistoryLag      int64  `json:"history_lag_ms"`
+	HeadBlock       uint32 `json:"head_block"`
+	LastActionBlock uint32 `json:"last_action_block"`
+	TransactionOk   bool   `json:"transaction_ok"`
+	FromGeo         string `json:"from_geo"`
+	Slow            bool   `json:"-"`
+	Scorecard
 }
 
 // CombineReport builds a Json file that has all of the timing data used to build the charts in the HTML so that it's
@@ -82,50 +312,3 @@ func CombineReport(report FinalResult, files []string, path string) []FinalResul
 	}
 	return combined
 }
-
-// MyGeo uses a service "address.works" to lookup the public IP being used, then uses maxmind's geolite to get a
-// country and region for reporting where the check originated from. It is not smart, expects the database to be
-// in the directory where the program is executing.
-func MyGeo(file string) (string, error) {
-	resp, err := http.Get("https://address.works/")
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-	b, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
-	}
-	ip := net.ParseIP(string(b))
-	db, err := maxminddb.Open(file)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer db.Close()
-
-	var record struct {
-		Country struct {
-			ISOCode string `maxminddb:"iso_code"`
-			Names   struct {
-				En string `maxminddb:"en"`
-			} `maxminddb:"names"`
-		} `maxminddb:"country"`
-		Subdivisions []struct {
-			IsoCode string `maxminddb:"iso_code"`
-		} `maxminddb:"subdivisions"`
-	}
-
-	err = db.Lookup(ip, &record)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	// If geolite cities was provided, give a little more granularity
-	if record.Subdivisions != nil && len(record.Subdivisions) > 0 {
-		var area string
-		area = "-" + record.Subdivisions[0].IsoCode
-		return record.Country.ISOCode + area, nil
-	}
-
-	return record.Country.Names.En, nil
-}
@@ -0,0 +1,213 @@
+package fiohealth
+
+import (
+	"fmt"
+	"github.com/oschwald/maxminddb-golang"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+)
+
+// GeoDb holds the GeoLite databases used to locate nodes, the ASN database is optional.
+type GeoDb struct {
+	country *maxminddb.Reader
+	asn     *maxminddb.Reader
+}
+
+// OpenGeo opens the country database, and the ASN database if the file exists.
+func OpenGeo(countryFile string, asnFile string) (*GeoDb, error) {
+	g := &GeoDb{}
+	var err error
+	g.country, err = maxminddb.Open(countryFile)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = os.Stat(asnFile); asnFile != "" && err == nil {
+		g.asn, err = maxminddb.Open(asnFile)
+		if err != nil {
+			_ = g.country.Close()
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// Close releases the databases
+func (g *GeoDb) Close() {
+	_ = g.country.Close()
+	if g.asn != nil {
+		_ = g.asn.Close()
+	}
+}
+
+// Locate finds the country and provider for an address, fields are left empty if it isn't found.
+func (g *GeoDb) Locate(ip string) *NodeLocation {
+	loc := &NodeLocation{Ip: ip}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return loc
+	}
+	var country struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+			Names   struct {
+				En string `maxminddb:"en"`
+			} `maxminddb:"names"`
+		} `maxminddb:"country"`
+	}
+	if g.country.Lookup(addr, &country) == nil {
+		loc.Country, loc.CountryName = country.Country.ISOCode, country.Country.Names.En
+	}
+	if g.asn == nil {
+		return loc
+	}
+	var asn struct {
+		Number       uint   `maxminddb:"autonomous_system_number"`
+		Organization string `maxminddb:"autonomous_system_organization"`
+	}
+	if g.asn.Lookup(addr, &asn) == nil {
+		loc.Asn, loc.Provider = asn.Number, asn.Organization
+	}
+	return loc
+}
+
+// Origin gives a short label for where an address is, the country and region if the database has subdivisions,
+// otherwise the country name.
+func (g *GeoDb) Origin(ip net.IP) string {
+	var record struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+			Names   struct {
+				En string `maxminddb:"en"`
+			} `maxminddb:"names"`
+		} `maxminddb:"country"`
+		Subdivisions []struct {
+			IsoCode string `maxminddb:"iso_code"`
+		} `maxminddb:"subdivisions"`
+	}
+	if err := g.country.Lookup(ip, &record); err != nil || record.Country.ISOCode == "" {
+		return "unknown"
+	}
+	// If geolite cities was provided, give a little more granularity
+	if len(record.Subdivisions) > 0 {
+		return record.Country.ISOCode + "-" + record.Subdivisions[0].IsoCode
+	}
+	return record.Country.Names.En
+}
+
+// LocateNodes finds the location of every API and P2P node using the first address its hostname resolves to, and
+// adds the diversity report to the final result.
+func LocateNodes(final *FinalResult, conf *Config) error {
+	g, err := OpenGeo(conf.Geolite, conf.AsnDb)
+	if err != nil {
+		return err
+	}
+	defer g.Close()
+	locate := func(host string) *NodeLocation {
+		ips, err := ResolveAll(host)
+		if err != nil || len(ips) == 0 {
+			return nil
+		}
+		return g.Locate(ips[0])
+	}
+
+	wg := sync.WaitGroup{}
+	for _, r := range final.Api {
+		u, err := url.Parse(r.Node)
+		if err != nil {
+			continue
+		}
+		wg.Add(1)
+		go func(r *Result, host string) {
+			defer wg.Done()
+			r.Location = locate(host)
+		}(r, u.Hostname())
+	}
+	for _, r := range final.P2p {
+		host, _, err := net.SplitHostPort(r.Peer)
+		if err != nil {
+			continue
+		}
+		wg.Add(1)
+		go func(r *P2pResult, host string) {
+			defer wg.Done()
+			r.Location = locate(host)
+		}(r, host)
+	}
+	wg.Wait()
+	final.Diversity = Diversity(final.Api, final.P2p, conf.MaxConcentration)
+	return nil
+}
+
+// Diversity counts the nodes in each country and provider. Warnings are given when more than maxPercent of the API
+// or P2P nodes share a provider, or a country if the provider is not known. Nodes that could not be located are
+// not counted.
+func Diversity(api []*Result, p2p []*P2pResult, maxPercent int) *DiversityReport {
+	countries := make(map[string]*DiversityCount)
+	providers := make(map[string]*DiversityCount)
+	count := func(m map[string]*DiversityCount, name string) *DiversityCount {
+		if m[name] == nil {
+			m[name] = &DiversityCount{Name: name}
+		}
+		return m[name]
+	}
+	var apiLocated, p2pLocated int
+	for _, r := range api {
+		if r.Location == nil || r.Location.Country == "" {
+			continue
+		}
+		apiLocated += 1
+		count(countries, r.Location.CountryName).Api += 1
+		if r.Location.Provider != "" {
+			count(providers, r.Location.Provider).Api += 1
+		}
+	}
+	for _, r := range p2p {
+		if r.Location == nil || r.Location.Country == "" {
+			continue
+		}
+		p2pLocated += 1
+		count(countries, r.Location.CountryName).P2p += 1
+		if r.Location.Provider != "" {
+			count(providers, r.Location.Provider).P2p += 1
+		}
+	}
+
+	d := &DiversityReport{
+		Countries: sortedCounts(countries),
+		Providers: sortedCounts(providers),
+	}
+	concentrated := d.Providers
+	kind := "hosted by"
+	if len(concentrated) == 0 {
+		concentrated = d.Countries
+		kind = "located in"
+	}
+	for _, c := range concentrated {
+		// with only a couple of nodes any provider is a large share, so don't warn
+		if apiLocated > 2 && c.Api*100 > apiLocated*maxPercent {
+			d.Warnings = append(d.Warnings, fmt.Sprintf("%d of %d API nodes are %s %s", c.Api, apiLocated, kind, c.Name))
+		}
+		if p2pLocated > 2 && c.P2p*100 > p2pLocated*maxPercent {
+			d.Warnings = append(d.Warnings, fmt.Sprintf("%d of %d P2P nodes are %s %s", c.P2p, p2pLocated, kind, c.Name))
+		}
+	}
+	return d
+}
+
+// sortedCounts orders by the total number of nodes, then by name
+func sortedCounts(m map[string]*DiversityCount) []*DiversityCount {
+	counts := make([]*DiversityCount, 0)
+	for _, c := range m {
+		counts = append(counts, c)
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Api+counts[i].P2p == counts[j].Api+counts[j].P2p {
+			return counts[i].Name < counts[j].Name
+		}
+		return counts[i].Api+counts[i].P2p > counts[j].Api+counts[j].P2p
+	})
+	return counts
+}
@@ -4,13 +4,17 @@ go 1.15
 
 require (
 	github.com/aws/aws-lambda-go v1.19.1
-	github.com/aws/aws-sdk-go v1.25.48
+	github.com/aws/aws-sdk-go v1.30.19
 	github.com/btcsuite/btcd v0.20.1-beta // indirect
 	github.com/fioprotocol/fio-go v1.0.1-0.20200901202349-dd2d7c115d59
 	github.com/go-telegram-bot-api/telegram-bot-api v4.6.4+incompatible
+	github.com/miekg/dns v1.1.31
 	github.com/oschwald/maxminddb-golang v1.7.0
 	github.com/refraction-networking/utls v0.0.0-20200820030103-33a29038e742
 	github.com/technoweenie/multipartstreamer v1.0.1 // indirect
+	github.com/xitongsys/parquet-go v1.6.0
+	github.com/xitongsys/parquet-go-source v0.0.0-20200817004010-026bad9b25d0
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
 	golang.org/x/text v0.3.3 // indirect
 	gopkg.in/yaml.v2 v2.2.2
 )
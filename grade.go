@@ -0,0 +1,103 @@
+package fiohealth
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+)
+
+// gradesFile holds the recent health of each node, used to average the grade over several runs
+const gradesFile = "json/grades.json"
+
+// AddScore records a finding and adds its weight to the score, findings with no weight are ignored.
+func (s *Scorecard) AddScore(check string, points float32, detail string) {
+	if points == 0 {
+		return
+	}
+	s.Score += points
+	s.Breakdown = append(s.Breakdown, &ScoreItem{Check: check, Points: points, Detail: detail})
+}
+
+// healthPercent converts a score into 0-100, a score of failed (the weight for a connection failure) or more is 0.
+func healthPercent(score float32, failed float32) int {
+	if failed <= 0 {
+		failed = 1
+	}
+	return int(math.Round(100 * (1 - math.Min(float64(score/failed), 1))))
+}
+
+// Grade converts health to a letter grade
+func Grade(health int) string {
+	switch {
+	case health >= 90:
+		return "A"
+	case health >= 80:
+		return "B"
+	case health >= 70:
+		return "C"
+	case health >= 60:
+		return "D"
+	}
+	return "F"
+}
+
+// GradeHistory is the health from recent runs, keyed by the type of check and node, newest last.
+type GradeHistory map[string][]int
+
+// LoadGrades gets the grade history, if it can't be loaded a new one is started.
+func LoadGrades(conf *Config) GradeHistory {
+	g := make(GradeHistory)
+	b, err := conf.ReadOutput(gradesFile)
+	if err != nil {
+		conf.Log("no grade history, creating new: " + err.Error())
+		return g
+	}
+	if err = json.Unmarshal(b, &g); err != nil {
+		log.Println("could not read grade history, creating new: " + err.Error())
+		return make(GradeHistory)
+	}
+	return g
+}
+
+// Save writes the grade history alongside the reports
+func (g GradeHistory) Save(conf *Config) error {
+	b, err := json.Marshal(g)
+	if err != nil {
+		return err
+	}
+	return conf.WriteOutput(gradesFile, b)
+}
+
+// Apply adds the health of each node in the report to the history, trimmed to the grade window, and sets the health
+// and grade of each node to the average over the window. Nodes that are no longer configured are removed.
+func (g GradeHistory) Apply(final *FinalResult, conf *Config) {
+	seen := make(map[string]bool)
+	update := func(key string, s *Scorecard, failed float32) {
+		seen[key] = true
+		recent := append(g[key], healthPercent(s.Score, failed))
+		if len(recent) > conf.GradeWindow {
+			recent = recent[len(recent)-conf.GradeWindow:]
+		}
+		g[key] = recent
+		var total int
+		for _, h := range recent {
+			total += h
+		}
+		s.Health = int(math.Round(float64(total) / float64(len(recent))))
+		s.Grade = Grade(s.Health)
+	}
+	for _, r := range final.Api {
+		update("api "+r.Node, &r.Scorecard, conf.Rules(r.Node).Api.Connection)
+	}
+	for _, r := range final.P2p {
+		update("p2p "+r.Peer, &r.Scorecard, conf.Rules(r.Peer).P2p.Connection)
+	}
+	for _, r := range final.History {
+		update("history "+r.Node, &r.Scorecard, conf.Rules(r.Node).History.Connection)
+	}
+	for key := range g {
+		if !seen[key] {
+			delete(g, key)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package fiohealth
+
+import (
+	"sort"
+	"strconv"
+	"time"
+)
+
+// maxIncidents limits how many incidents are listed on a node page
+const maxIncidents = 20
+
+// NodePage is the data for a node's detail page, built from the recent runs in json/report.json. It is also
+// written as json alongside the page.
+type NodePage struct {
+	Title     string         `json:"title"`
+	Kind      string         `json:"kind"` // api, p2p, or history
+	Node      string         `json:"node"`
+	Updated   string         `json:"updated"`
+	Api       *Result        `json:"api,omitempty"`
+	P2p       *P2pResult     `json:"p2p,omitempty"`
+	History   *HistoryResult `json:"history,omitempty"`
+	Uptime    []*NodeUptime  `json:"uptime"`
+	Versions  []*NodeVersion `json:"versions"`
+	Incidents []*Incident    `json:"incidents"`
+	Samples   []*NodeSample  `json:"samples"`
+}
+
+// NodeUptime is the share of checks from a test origin that passed
+type NodeUptime struct {
+	Origin  string  `json:"origin"`
+	Checks  int     `json:"checks"`
+	Passed  int     `json:"passed"`
+	Percent float64 `json:"percent"`
+}
+
+// NodeVersion is a version (or p2p agent) reported by the node, and when it was seen
+type NodeVersion struct {
+	Version   string `json:"version"`
+	FirstSeen int64  `json:"first_seen"`
+	LastSeen  int64  `json:"last_seen"`
+}
+
+// Incident is a run of consecutive failed checks from a single test origin, End is 0 if it is ongoing
+type Incident struct {
+	Origin string `json:"origin"`
+	Start  int64  `json:"start"`
+	End    int64  `json:"end"`
+	Checks int    `json:"checks"`
+	Reason string `json:"reason"`
+}
+
+// NodeSample is a single check from the recent runs, used for the uptime, incidents, and charts
+type NodeSample struct {
+	Timestamp int64  `json:"timestamp"`
+	Origin    string `json:"origin"`
+	Healthy   bool   `json:"healthy"`
+	Latency   int64  `json:"latency_ms,omitempty"`
+	Lag       int64  `json:"lag_ms"`
+	Version   string `json:"version,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// NodePageName is the file name for a node's page, without an extension. History checks get their own page since
+// the node may also be an API node.
+func NodePageName(kind string, node string) string {
+	if kind == "history" {
+		return NodeFileName(node) + "_history"
+	}
+	return NodeFileName(node)
+}
+
+// PageUrl is the relative link to the node's detail page, used by the report template
+func (r *Result) PageUrl() string {
+	return "nodes/" + NodePageName("api", r.Node) + ".html"
+}
+
+// PageUrl is the relative link to the peer's detail page, used by the report template
+func (p *P2pResult) PageUrl() string {
+	return "nodes/" + NodePageName("p2p", p.Peer) + ".html"
+}
+
+// PageUrl is the relative link to the history node's detail page, used by the report template
+func (h *HistoryResult) PageUrl() string {
+	return "nodes/" + NodePageName("history", h.Node) + ".html"
+}
+
+// FileName is the name used for the page and its json, without an extension
+func (p *NodePage) FileName() string {
+	return NodePageName(p.Kind, p.Node)
+}
+
+// Started formats the start of an incident for the template
+func (i *Incident) Started() string {
+	return time.Unix(i.Start, 0).UTC().Format(time.UnixDate)
+}
+
+// Ended formats the end of an incident for the template
+func (i *Incident) Ended() string {
+	if i.End == 0 {
+		return "ongoing"
+	}
+	return time.Unix(i.End, 0).UTC().Format(time.UnixDate)
+}
+
+// Seen formats when a version was reported for the template
+func (v *NodeVersion) Seen() string {
+	return time.Unix(v.FirstSeen, 0).UTC().Format("2006-01-02 15:04") + " - " +
+		time.Unix(v.LastSeen, 0).UTC().Format("2006-01-02 15:04")
+}
+
+// NodePages builds a page for each node in the current run, using the recent runs (which include the current run,
+// as returned by CombineReport) for the uptime, versions, incidents, and charts. Only the hostname results are
+// used, not the results for each address.
+func NodePages(final FinalResult, combined []FinalResult) []*NodePage {
+	samples := make(map[string][]*NodeSample)
+	add := func(key string, s *NodeSample) {
+		samples[key] = append(samples[key], s)
+	}
+	for _, run := range combined {
+		for _, r := range run.Api {
+			add("api "+r.Node, &NodeSample{
+				Timestamp: r.TimeStamp,
+				Origin:    r.FromGeo,
+				Healthy:   !r.HadError,
+				Latency:   r.RequestLatency,
+				Lag:       r.HeadBlockLatency,
+				Version:   r.NodeVer,
+				Reason:    r.Error,
+			})
+		}
+		for _, p := range run.P2p {
+			s := &NodeSample{
+				Timestamp: p.TimeStamp,
+				Origin:    p.FromGeo,
+				Healthy:   p.Healthy,
+				Lag:       p.HeadBlockLatency,
+				Reason:    p.ErrMsg,
+			}
+			if p.Handshake != nil {
+				s.Version = p.Handshake.Agent + " (net " + strconv.Itoa(int(p.Handshake.NetworkVersion)) + ")"
+			}
+			add("p2p "+p.Peer, s)
+		}
+		for _, h := range run.History {
+			add("history "+h.Node, &NodeSample{
+				Timestamp: h.TimeStamp,
+				Origin:    h.FromGeo,
+				Healthy:   !h.HadError,
+				Latency:   h.RequestLatency,
+				Lag:       h.HistoryLag,
+				Reason:    h.Error,
+			})
+		}
+	}
+
+	pages := make([]*NodePage, 0)
+	newPage := func(kind string, node string) *NodePage {
+		p := &NodePage{
+			Title:   final.Description,
+			Kind:    kind,
+			Node:    node,
+			Updated: final.Timestamp,
+			Samples: samples[kind+" "+node],
+		}
+		p.summarize()
+		pages = append(pages, p)
+		return p
+	}
+	for _, r := range final.Api {
+		newPage("api", r.Node).Api = r
+	}
+	for _, p := range final.P2p {
+		newPage("p2p", p.Peer).P2p = p
+	}
+	for _, h := range final.History {
+		newPage("history", h.Node).History = h
+	}
+	return pages
+}
+
+// summarize fills in the uptime, versions, and incidents from the samples
+func (p *NodePage) summarize() {
+	sort.SliceStable(p.Samples, func(i, j int) bool {
+		return p.Samples[i].Timestamp < p.Samples[j].Timestamp
+	})
+	p.Uptime = make([]*NodeUptime, 0)
+	p.Versions = make([]*NodeVersion, 0)
+	p.Incidents = make([]*Incident, 0)
+	if p.Samples == nil {
+		p.Samples = make([]*NodeSample, 0)
+	}
+
+	uptime := make(map[string]*NodeUptime)
+	open := make(map[string]*Incident)
+	for _, s := range p.Samples {
+		if uptime[s.Origin] == nil {
+			uptime[s.Origin] = &NodeUptime{Origin: s.Origin}
+			p.Uptime = append(p.Uptime, uptime[s.Origin])
+		}
+		u := uptime[s.Origin]
+		u.Checks += 1
+		if s.Healthy {
+			u.Passed += 1
+		}
+		u.Percent = float64(u.Passed*1000/u.Checks) / 10
+
+		if s.Version != "" {
+			if n := len(p.Versions); n > 0 && p.Versions[n-1].Version == s.Version {
+				p.Versions[n-1].LastSeen = s.Timestamp
+			} else {
+				p.Versions = append(p.Versions, &NodeVersion{Version: s.Version, FirstSeen: s.Timestamp, LastSeen: s.Timestamp})
+			}
+		}
+
+		switch i := open[s.Origin]; {
+		case !s.Healthy && i == nil:
+			open[s.Origin] = &Incident{Origin: s.Origin, Start: s.Timestamp, Checks: 1, Reason: s.Reason}
+			p.Incidents = append(p.Incidents, open[s.Origin])
+		case !s.Healthy:
+			i.Checks += 1
+		case i != nil:
+			i.End = s.Timestamp
+			delete(open, s.Origin)
+		}
+	}
+	sort.Slice(p.Uptime, func(i, j int) bool {
+		return p.Uptime[i].Origin < p.Uptime[j].Origin
+	})
+	// newest first
+	for i, j := 0, len(p.Incidents)-1; i < j; i, j = i+1, j-1 {
+		p.Incidents[i], p.Incidents[j] = p.Incidents[j], p.Incidents[i]
+	}
+	if len(p.Incidents) > maxIncidents {
+		p.Incidents = p.Incidents[:maxIncidents]
+	}
+}
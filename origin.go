@@ -0,0 +1,84 @@
+package fiohealth
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultIpProviders are used to find the public address when ip_providers is not set, they are tried in order.
+var DefaultIpProviders = []string{
+	"https://address.works/",
+	"https://checkip.amazonaws.com/",
+	"https://api.ipify.org/",
+}
+
+// IpProvider discovers the public address being used by the health checks
+type IpProvider interface {
+	PublicIp() (net.IP, error)
+}
+
+// HttpIpProvider is the url of a service that responds with the caller's address as plain text
+type HttpIpProvider string
+
+var ipProviderClient = &http.Client{Timeout: 5 * time.Second}
+
+func (h HttpIpProvider) PublicIp() (net.IP, error) {
+	resp, err := ipProviderClient.Get(string(h))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(string(h) + " returned " + resp.Status)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(strings.TrimSpace(string(b)))
+	if ip == nil {
+		return nil, errors.New(string(h) + " did not return an IP address")
+	}
+	return ip, nil
+}
+
+// TestOrigin gives the label used to show where the checks were run from. It is looked up once and cached. In order
+// of preference: the origin setting, or the public address (found using IpProviders) located using the GeoLite
+// database. If neither works it is "unknown". This is also used on lambda so the label matches earlier results.
+func (c *Config) TestOrigin() string {
+	c.originOnce.Do(func() {
+		c.origin = c.findOrigin()
+	})
+	return c.origin
+}
+
+func (c *Config) findOrigin() string {
+	if c.Origin != "" {
+		return c.Origin
+	}
+	var ip net.IP
+	for _, provider := range c.IpProviders {
+		var err error
+		ip, err = provider.PublicIp()
+		if err == nil {
+			break
+		}
+		c.Log("public ip lookup failed: " + err.Error())
+	}
+	if ip == nil {
+		log.Println("could not determine public address for test origin")
+		return "unknown"
+	}
+	g, err := OpenGeo(c.Geolite, "")
+	if err != nil {
+		log.Println("could not open geolite database for test origin: " + err.Error())
+		return "unknown"
+	}
+	defer g.Close()
+	return g.Origin(ip)
+}
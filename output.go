@@ -0,0 +1,41 @@
+package fiohealth
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// WriteOutput saves a file relative to the configured output directory, either local or S3.
+func (c *Config) WriteOutput(name string, b []byte) error {
+	if c.Bucket != "" {
+		return S3Put(c.Bucket, c.Prefix+"/"+name, b, c.Region)
+	}
+	path := c.OutputDir + "/" + name
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+// ReadOutput loads a file previously saved with WriteOutput
+func (c *Config) ReadOutput(name string) ([]byte, error) {
+	if c.Bucket != "" {
+		return S3Get(c.Bucket, c.Prefix+"/"+name, c.Region)
+	}
+	return ioutil.ReadFile(c.OutputDir + "/" + name)
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+
+// NodeFileName converts a node url or host:port into a string that is safe to use as a file name, used for any
+// per-node output.
+func NodeFileName(node string) string {
+	if i := strings.Index(node, "://"); i >= 0 {
+		node = node[i+3:]
+	}
+	return unsafeFileChars.ReplaceAllString(strings.TrimRight(node, "/"), "_")
+}
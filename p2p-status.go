@@ -0,0 +1,102 @@
+package fiohealth
+
+import (
+	"errors"
+	"github.com/fioprotocol/fio-go/eos"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// P2pStatus classifies the outcome of a p2p check so that reporting and alerting don't need to inspect error text
+type P2pStatus string
+
+const (
+	P2pOk             P2pStatus = "ok"
+	P2pLagging        P2pStatus = "lagging"
+	P2pStaleLib       P2pStatus = "stale_lib"
+	P2pStreamDegraded P2pStatus = "stream_degraded"
+	P2pNoBlocks       P2pStatus = "no_blocks"
+
+	// go away reasons
+	P2pGoAwayNoReason    P2pStatus = "go_away"
+	P2pSelfConnect       P2pStatus = "self_connect"
+	P2pDuplicate         P2pStatus = "duplicate"
+	P2pWrongChain        P2pStatus = "wrong_chain"
+	P2pWrongVersion      P2pStatus = "wrong_version"
+	P2pForked            P2pStatus = "forked"
+	P2pUnlinkable        P2pStatus = "unlinkable"
+	P2pBadTransaction    P2pStatus = "bad_transaction"
+	P2pValidation        P2pStatus = "validation"
+	P2pAuthentication    P2pStatus = "authentication"
+	P2pFatalOther        P2pStatus = "fatal_other"
+	P2pBenignOther       P2pStatus = "benign_other"
+	P2pCrazy             P2pStatus = "crazy"
+	P2pInvalidGoAwayCode P2pStatus = "invalid_go_away"
+
+	// connection errors
+	P2pPeerLimit    P2pStatus = "peer_limit"
+	P2pTooSlow      P2pStatus = "too_slow"
+	P2pTimeout      P2pStatus = "timeout"
+	P2pNameLookup   P2pStatus = "name_lookup_failed"
+	P2pConnReset    P2pStatus = "connection_reset"
+	P2pConnRefused  P2pStatus = "connection_refused"
+	P2pConnectError P2pStatus = "connection_error"
+)
+
+var goAwayStatus = map[eos.GoAwayReason]P2pStatus{
+	eos.GoAwayNoReason:       P2pGoAwayNoReason,
+	eos.GoAwaySelfConnect:    P2pSelfConnect,
+	eos.GoAwayDuplicate:      P2pDuplicate,
+	eos.GoAwayWrongChain:     P2pWrongChain,
+	eos.GoAwayWrongVersion:   P2pWrongVersion,
+	eos.GoAwayForked:         P2pForked,
+	eos.GoAwayUnlinkable:     P2pUnlinkable,
+	eos.GoAwayBadTransaction: P2pBadTransaction,
+	eos.GoAwayValidation:     P2pValidation,
+	eos.GoAwayAuthentication: P2pAuthentication,
+	eos.GoAwayFatalOther:     P2pFatalOther,
+	eos.GoAwayBenignOther:    P2pBenignOther,
+	eos.GoAwayCrazy:          P2pCrazy,
+}
+
+// GoAwayStatus converts a go away reason code to a status
+func GoAwayStatus(reason eos.GoAwayReason) P2pStatus {
+	if status, ok := goAwayStatus[reason]; ok {
+		return status
+	}
+	return P2pInvalidGoAwayCode
+}
+
+// ConnectionStatus classifies an error returned by the p2p client, and provides a short description for the report.
+func ConnectionStatus(err error) (P2pStatus, string) {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch true {
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		// nodeos closes the connection without a go away when max-clients is reached
+		return P2pPeerLimit, "too many peers? (EOF)"
+	case strings.HasSuffix(err.Error(), "use of closed network connection"):
+		// we closed it, the peer didn't send anything useful in time
+		return P2pTooSlow, "too slow to respond"
+	case errors.As(err, &dnsErr):
+		return P2pNameLookup, "name lookup failed"
+	case errors.Is(err, syscall.ECONNRESET):
+		return P2pConnReset, "connection reset"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return P2pConnRefused, "connection refused"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return P2pTimeout, "connection timeout"
+	}
+	return P2pConnectError, err.Error()
+}
+
+// Alarm is false for statuses that are expected to be transient, or are a limitation rather than a fault.
+func (s P2pStatus) Alarm() bool {
+	switch s {
+	case P2pOk, P2pPeerLimit, P2pBenignOther, P2pDuplicate:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,69 @@
+package fiohealth
+
+// ReportData is passed to the report template. The results for this run are embedded, so templates written for
+// FinalResult (.Api, .P2p, .History, .Diversity, .Timestamp, .Description) keep working. See Templates in the README.
+type ReportData struct {
+	FinalResult
+	Title     string                    // report_title from the config
+	BaseUrl   string                    // base_url from the config, may be empty
+	ApiAlerts map[string]*ApiAlertState // alarm state for API and history nodes, by alarm key
+	P2pAlerts map[string]*P2pAlertState // alarm state for p2p nodes, by alarm key
+	Nodes     []*NodePage               // the data for each node page, including uptime from each test origin
+	Runs      int                       // how many recent runs the uptime and incidents are from
+}
+
+// NewReportData builds the template data from this run, and the recent runs returned by CombineReport
+func NewReportData(conf *Config, final FinalResult, combined []FinalResult) *ReportData {
+	d := &ReportData{
+		FinalResult: final,
+		Title:       conf.ReportTitle,
+		BaseUrl:     conf.BaseUrl,
+		ApiAlerts:   make(map[string]*ApiAlertState),
+		P2pAlerts:   make(map[string]*P2pAlertState),
+		Nodes:       NodePages(final, combined),
+		Runs:        len(combined),
+	}
+	if conf.ApiAlerts != nil {
+		conf.ApiAlerts.RLock()
+		for k, v := range conf.ApiAlerts.State {
+			d.ApiAlerts[k] = v
+		}
+		conf.ApiAlerts.RUnlock()
+	}
+	if conf.P2pAlerts != nil {
+		conf.P2pAlerts.Lock()
+		for k, v := range conf.P2pAlerts.State {
+			d.P2pAlerts[k] = v
+		}
+		conf.P2pAlerts.Unlock()
+	}
+	return d
+}
+
+// Node finds the page data for a node, kind is api, p2p, or history. Returns nil if the node is not in this run.
+func (d *ReportData) Node(kind string, node string) *NodePage {
+	for _, p := range d.Nodes {
+		if p.Kind == kind && p.Node == node {
+			return p
+		}
+	}
+	return nil
+}
+
+// Uptime is the share of checks that passed over the recent runs, from every test origin, kind is api, p2p, or
+// history. Returns nil if the node is not in this run.
+func (d *ReportData) Uptime(kind string, node string) *NodeUptime {
+	p := d.Node(kind, node)
+	if p == nil {
+		return nil
+	}
+	u := &NodeUptime{Origin: "all"}
+	for _, o := range p.Uptime {
+		u.Checks += o.Checks
+		u.Passed += o.Passed
+	}
+	if u.Checks > 0 {
+		u.Percent = float64(u.Passed*1000/u.Checks) / 10
+	}
+	return u
+}
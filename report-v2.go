@@ -0,0 +1,254 @@
+package fiohealth
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// ReportV2 is a single run in the v2 layout. Compared to v1: every timestamp is unix seconds named timestamp, every
+// duration is in milliseconds with an _ms suffix, the test origin is recorded once per run, problems are only
+// listed as findings (there are no error summary strings), and the score, health, and grade are grouped together.
+type ReportV2 struct {
+	SchemaVersion int              `json:"schema_version"`
+	Timestamp     int64            `json:"timestamp"`
+	Description   string           `json:"description"`
+	Origin        string           `json:"origin"`
+	Api           []*ApiNodeV2     `json:"api"`
+	P2p           []*P2pNodeV2     `json:"p2p"`
+	History       []*HistoryNodeV2 `json:"history"`
+	Diversity     *DiversityReport `json:"diversity,omitempty"`
+}
+
+// CombinedReportV2 holds the recent runs, oldest first, it replaces the bare array used by v1's report.json
+type CombinedReportV2 struct {
+	SchemaVersion int         `json:"schema_version"`
+	Runs          []*ReportV2 `json:"runs"`
+}
+
+// ScoreV2 is a node's score, and the health and grade averaged over recent runs
+type ScoreV2 struct {
+	Points    float32      `json:"points"`
+	Health    int          `json:"health"`
+	Grade     string       `json:"grade,omitempty"`
+	Breakdown []*ScoreItem `json:"breakdown"`
+}
+
+// TlsV2 groups the TLS results for an API node
+type TlsV2 struct {
+	Enabled   bool       `json:"enabled"`
+	VersionOk bool       `json:"version_ok"`
+	CipherOk  bool       `json:"cipher_ok"`
+	Cert      *CertAudit `json:"cert,omitempty"`
+}
+
+// ApiNodeV2 is an API node result in the v2 layout
+type ApiNodeV2 struct {
+	Node             string             `json:"node"`
+	Ip               string             `json:"ip,omitempty"`
+	Timestamp        int64              `json:"timestamp"`
+	Healthy          bool               `json:"healthy"`
+	Version          string             `json:"version"`
+	RequestLatency   int64              `json:"request_latency_ms"`
+	HeadBlockLatency int64              `json:"head_block_latency_ms"`
+	PermissiveCors   bool               `json:"permissive_cors"`
+	Tls              TlsV2              `json:"tls"`
+	Headers          []*HeaderFinding   `json:"headers"`
+	Security         []*SecurityFinding `json:"security"`
+	Ipv4             *FamilyResult      `json:"ipv4,omitempty"`
+	Ipv6             *FamilyResult      `json:"ipv6,omitempty"`
+	Dns              *DnsResult         `json:"dns,omitempty"`
+	Location         *NodeLocation      `json:"location,omitempty"`
+	Addresses        []*ApiNodeV2       `json:"addresses,omitempty"`
+	Findings         []*Finding         `json:"findings"`
+	Score            ScoreV2            `json:"score"`
+}
+
+// P2pNodeV2 is a P2P peer result in the v2 layout
+type P2pNodeV2 struct {
+	Peer             string         `json:"peer"`
+	Ip               string         `json:"ip,omitempty"`
+	Timestamp        int64          `json:"timestamp"`
+	Healthy          bool           `json:"healthy"`
+	Reachable        bool           `json:"reachable"`
+	Status           P2pStatus      `json:"status"`
+	HeadBlockLatency int64          `json:"head_block_latency_ms"`
+	Took             int64          `json:"took_ms"`
+	Handshake        *PeerHandshake `json:"handshake,omitempty"`
+	Stream           *BlockStream   `json:"stream,omitempty"`
+	Sync             *SyncResult    `json:"sync,omitempty"`
+	Ipv4             *FamilyResult  `json:"ipv4,omitempty"`
+	Ipv6             *FamilyResult  `json:"ipv6,omitempty"`
+	Dns              *DnsResult     `json:"dns,omitempty"`
+	Location         *NodeLocation  `json:"location,omitempty"`
+	Addresses        []*P2pNodeV2   `json:"addresses,omitempty"`
+	Findings         []*Finding     `json:"findings"`
+	Score            ScoreV2        `json:"score"`
+}
+
+// HistoryNodeV2 is a history node result in the v2 layout
+type HistoryNodeV2 struct {
+	Node            string     `json:"node"`
+	Timestamp       int64      `json:"timestamp"`
+	Healthy         bool       `json:"healthy"`
+	RequestLatency  int64      `json:"request_latency_ms"`
+	HistoryLag      int64      `json:"history_lag_ms"`
+	HeadBlock       uint32     `json:"head_block"`
+	LastActionBlock uint32     `json:"last_action_block"`
+	TransactionOk   bool       `json:"transaction_ok"`
+	Findings        []*Finding `json:"findings"`
+	Score           ScoreV2    `json:"score"`
+}
+
+// V2 converts a report to the v2 layout. Reports written before findings were recorded get a single finding built
+// from the error summary.
+func (f FinalResult) V2() *ReportV2 {
+	r := &ReportV2{
+		SchemaVersion: SchemaV2,
+		Description:   f.Description,
+		Api:           make([]*ApiNodeV2, 0),
+		P2p:           make([]*P2pNodeV2, 0),
+		History:       make([]*HistoryNodeV2, 0),
+		Diversity:     f.Diversity,
+	}
+	if t, err := time.Parse(time.UnixDate, f.Timestamp); err == nil {
+		r.Timestamp = t.Unix()
+	}
+	for _, a := range f.Api {
+		if r.Origin == "" {
+			r.Origin = a.FromGeo
+		}
+		r.Api = append(r.Api, apiV2(a))
+	}
+	for _, p := range f.P2p {
+		if r.Origin == "" {
+			r.Origin = p.FromGeo
+		}
+		r.P2p = append(r.P2p, p2pV2(p))
+	}
+	for _, h := range f.History {
+		r.History = append(r.History, &HistoryNodeV2{
+			Node:            h.Node,
+			Timestamp:       h.TimeStamp,
+			Healthy:         !h.HadError,
+			RequestLatency:  h.RequestLatency,
+			HistoryLag:      h.HistoryLag,
+			HeadBlock:       h.HeadBlock,
+			LastActionBlock: h.LastActionBlock,
+			TransactionOk:   h.TransactionOk,
+			Findings:        legacyFindings(h.Findings, h.HadError, h.ErrorFor, h.Error),
+			Score:           scoreV2(h.Scorecard),
+		})
+	}
+	return r
+}
+
+func apiV2(a *Result) *ApiNodeV2 {
+	n := &ApiNodeV2{
+		Node:             a.Node,
+		Ip:               a.Ip,
+		Timestamp:        a.TimeStamp,
+		Healthy:          !a.HadError,
+		Version:          a.NodeVer,
+		RequestLatency:   a.RequestLatency,
+		HeadBlockLatency: a.HeadBlockLatency,
+		PermissiveCors:   a.PermissiveCors,
+		Tls: TlsV2{
+			Enabled:   strings.HasPrefix(a.Node, "https") && a.TlsNote != "TLS not enabled",
+			VersionOk: a.TlsVerOk,
+			CipherOk:  a.TlsCipherOk,
+			Cert:      a.Cert,
+		},
+		Headers:  a.Headers,
+		Security: a.Security,
+		Ipv4:     a.Ipv4,
+		Ipv6:     a.Ipv6,
+		Dns:      a.Dns,
+		Location: a.Location,
+		Findings: legacyFindings(a.Findings, a.HadError, a.ErrorFor, a.Error),
+		Score:    scoreV2(a.Scorecard),
+	}
+	for _, addr := range a.Addresses {
+		n.Addresses = append(n.Addresses, apiV2(addr))
+	}
+	return n
+}
+
+func p2pV2(p *P2pResult) *P2pNodeV2 {
+	n := &P2pNodeV2{
+		Peer:             p.Peer,
+		Ip:               p.Ip,
+		Timestamp:        p.TimeStamp,
+		Healthy:          p.Healthy,
+		Reachable:        p.Reachable,
+		Status:           p.Status,
+		HeadBlockLatency: p.HeadBlockLatency,
+		Took:             p.TookMs,
+		Handshake:        p.Handshake,
+		Stream:           p.Stream,
+		Sync:             p.Sync,
+		Ipv4:             p.Ipv4,
+		Ipv6:             p.Ipv6,
+		Dns:              p.Dns,
+		Location:         p.Location,
+		Findings:         legacyFindings(p.Findings, !p.Healthy, "connection", p.ErrMsg),
+		Score:            scoreV2(p.Scorecard),
+	}
+	// took_ms was added with schema versioning, older reports only have whole seconds
+	if n.Took == 0 {
+		n.Took = p.Took * 1000
+	}
+	for _, addr := range p.Addresses {
+		n.Addresses = append(n.Addresses, p2pV2(addr))
+	}
+	return n
+}
+
+func scoreV2(s Scorecard) ScoreV2 {
+	breakdown := s.Breakdown
+	if breakdown == nil {
+		breakdown = make([]*ScoreItem, 0)
+	}
+	return ScoreV2{Points: s.Score, Health: s.Health, Grade: s.Grade, Breakdown: breakdown}
+}
+
+// legacyFindings gives the findings for a result, or one built from the error message for results from older reports
+func legacyFindings(findings []*Finding, failed bool, errFor string, msg string) []*Finding {
+	if len(findings) > 0 {
+		return findings
+	}
+	if !failed || msg == "" {
+		return make([]*Finding, 0)
+	}
+	return []*Finding{{
+		Check:    strings.ReplaceAll(errFor, " ", "_"),
+		Category: CategoryHealth,
+		Severity: SeverityCritical,
+		Message:  msg,
+	}}
+}
+
+// WriteReportV2 writes the run and the recent runs in the v2 layout under json/v2/, using the same file name as the
+// v1 run so that json/index.json lists both.
+func WriteReportV2(conf *Config, run FinalResult, combined []FinalResult, name string) error {
+	j, err := json.MarshalIndent(run.V2(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = conf.WriteOutput("json/v2/"+name, j); err != nil {
+		return err
+	}
+	c := &CombinedReportV2{SchemaVersion: SchemaV2, Runs: make([]*ReportV2, 0)}
+	for _, f := range combined {
+		// runs that could not be read are left empty by CombineReport
+		if f.Timestamp == "" {
+			continue
+		}
+		c.Runs = append(c.Runs, f.V2())
+	}
+	j, err = json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return conf.WriteOutput("json/v2/report.json", j)
+}
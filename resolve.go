@@ -0,0 +1,81 @@
+package fiohealth
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"time"
+)
+
+// ResolveAll returns every A and AAAA record for a host, sorted so the order is stable between runs. If the host
+// is already an IP address it is returned unchanged.
+func ResolveAll(host string) ([]string, error) {
+	if net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]string, 0)
+	for _, a := range addrs {
+		ips = append(ips, a.IP.String())
+	}
+	sort.Strings(ips)
+	return ips, nil
+}
+
+// ResolveFamily is like ResolveAll but only returns addresses for one family, network is "ip4" or "ip6".
+func ResolveFamily(host string, network string) ([]string, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		if (ip.To4() != nil) == (network == "ip4") {
+			return []string{host}, nil
+		}
+		return []string{}, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIP(ctx, network, host)
+	if err != nil {
+		// no records for the family is not an error here
+		var dnsErr *net.DNSError
+		var addrErr *net.AddrError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound || errors.As(err, &addrErr) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+	ips := make([]string, 0)
+	for _, a := range addrs {
+		ips = append(ips, a.String())
+	}
+	sort.Strings(ips)
+	return ips, nil
+}
+
+// pinnedDial returns a DialContext func for an http.Transport that always connects to ip, keeping the requested
+// port. The request still uses the hostname, so SNI and the Host header are unchanged.
+func pinnedDial(ip string) func(ctx context.Context, network string, addr string) (net.Conn, error) {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	return func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		_, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		return dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+	}
+}
+
+// dialAddress gives the address to connect to for a host and port, using ip instead of host if it is set.
+func dialAddress(host string, ip string, port string) string {
+	if ip != "" {
+		return net.JoinHostPort(ip, port)
+	}
+	return net.JoinHostPort(host, port)
+}
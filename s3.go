@@ -9,6 +9,8 @@ import (
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"log"
+	"mime"
+	"path"
 	"sort"
 	"strings"
 )
@@ -49,12 +51,20 @@ func S3Put(s3Bucket string, s3File string, f []byte, region string) error {
 	case strings.HasSuffix(s3File, ".html"):
 		contentType = "text/html"
 		maxAge = "max-age=120"
-	case strings.HasSuffix(s3File, "index.json"), strings.HasSuffix(s3File, "report.json"):
+	case strings.HasSuffix(s3File, "index.json"), strings.HasSuffix(s3File, "report.json"), strings.Contains(s3File, "/nodes/"),
+		strings.Contains(s3File, "/tls/"), strings.HasPrefix(s3File, "tls/"):
 		contentType = "application/json"
 		maxAge = "max-age=120"
+	case strings.HasSuffix(s3File, "feed.xml"):
+		contentType = "application/atom+xml"
+		maxAge = "max-age=120"
 	case strings.HasSuffix(s3File, ".json"):
 		contentType = "application/json"
 		maxAge = "max-age=86400"
+	case strings.Contains(s3File, "/badges/"):
+		// embedded on other sites, so should not be cached for long
+		contentType = "image/svg+xml"
+		maxAge = "max-age=300, must-revalidate"
 	case strings.HasSuffix(s3File, ".svg"):
 		contentType = "image/svg+xml"
 		maxAge = "max-age=86400"
@@ -63,7 +73,10 @@ func S3Put(s3Bucket string, s3File string, f []byte, region string) error {
 		maxAge = "max-age=86400"
 	case strings.HasSuffix(s3File, ".js"):
 		contentType = "application/javascript"
-		maxAge = "max-age=120"
+		maxAge = "max-age=86400"
+	default:
+		// assets from a custom template directory may be any type
+		contentType = mime.TypeByExtension(path.Ext(s3File))
 	}
 
 	buff := bytes.NewBuffer(f)
@@ -84,6 +97,32 @@ func S3Put(s3Bucket string, s3File string, f []byte, region string) error {
 	return nil
 }
 
+// S3Delete removes a file from s3
+func S3Delete(s3Bucket string, s3File string, region string) error {
+	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String(region)}))
+	_, err := s3.New(sess).DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(s3Bucket),
+		Key:    aws.String(s3File),
+	})
+	return err
+}
+
+// S3List gets the names of the files under a prefix, relative to the prefix
+func S3List(s3Bucket string, prefix string, region string) ([]string, error) {
+	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String(region)}))
+	names := make([]string, 0)
+	err := s3.New(sess).ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(s3Bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, o := range page.Contents {
+			names = append(names, strings.TrimPrefix(*o.Key, prefix))
+		}
+		return true
+	})
+	return names, err
+}
+
 func CombineS3Report(report FinalResult, files []string, bucket string, prefix string, region string) []FinalResult {
 	combined := make([]FinalResult, len(files)+1)
 	combined[len(combined)-1] = report
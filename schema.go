@@ -0,0 +1,203 @@
+package fiohealth
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Report schema versions, written in the schema_version field. Files written before versioning was added have no
+// schema_version and use the v1 layout.
+const (
+	SchemaV1 = 1
+	SchemaV2 = 2
+)
+
+// HistoryEntry is a single html snapshot listed in history/index.json
+type HistoryEntry struct {
+	File string `json:"file"`
+	Date string `json:"date"`
+	From string `json:"from"`
+
+	Sort int64 `json:"-"`
+}
+
+// v1Required lists the fields, by type, that every v1 file has. Everything else was added over time, so older files
+// don't have it, including schema_version.
+var v1Required = map[string][]string{
+	"FinalResult": {"api", "p2p", "timestamp", "description"},
+	"Result": {"type", "node", "node_ver", "timestamp", "had_error", "error", "error_for", "request_latency_ms",
+		"head_block_latency_ms", "permissive_cors", "tls_ver_ok", "tls_cipher_ok", "tls_note", "from_geo"},
+	"P2pResult": {"type", "peer", "time_stamp", "took_sec", "reachable", "healthy", "head_block_latency_ms",
+		"err_msg", "from_geo"},
+	"HistoryEntry":  {"file", "date", "from"},
+	"ApiAlerts":     {"state"},
+	"ApiAlertState": {"health_alarm", "health_reason", "health_not_before", "security_alarm", "security_reason", "security_not_before"},
+	"P2pAlerts":     {"State"},
+	"P2pAlertState": {"alarm", "reason", "not_before"},
+}
+
+// schemaFiles are the published JSON Schema documents, and the type each one describes. If required is set, only the
+// fields listed in it are required.
+var schemaFiles = []struct {
+	name     string
+	title    string
+	v        interface{}
+	required map[string][]string
+}{
+	{"schema/v1/report.json", "fio.health single run (json/<timestamp>.json)", FinalResult{}, v1Required},
+	{"schema/v1/combined.json", "fio.health recent runs (json/report.json)", []FinalResult{}, v1Required},
+	{"schema/v1/json-index.json", "fio.health run index (json/index.json)", []string{}, v1Required},
+	{"schema/v1/history-index.json", "fio.health html snapshot index (history/index.json)", []HistoryEntry{}, v1Required},
+	{"schema/v1/api-alerts.json", "fio.health API alert state (json/api_health.json)", ApiAlerts{}, v1Required},
+	{"schema/v1/p2p-alerts.json", "fio.health P2P alert state (json/p2p_health.json)", P2pAlerts{}, v1Required},
+	{"schema/v2/report.json", "fio.health single run (json/v2/<timestamp>.json)", ReportV2{}, nil},
+	{"schema/v2/combined.json", "fio.health recent runs (json/v2/report.json)", CombinedReportV2{}, nil},
+}
+
+// WriteSchemas publishes the JSON Schema documents for the report, index, and alert state files under schema/ in
+// the output directory. They are generated from the types, so they always match what is written.
+func (c *Config) WriteSchemas() error {
+	for _, s := range schemaFiles {
+		id := s.name
+		if c.BaseUrl != "" {
+			id = strings.TrimRight(c.BaseUrl, "/") + "/" + s.name
+		}
+		b, err := jsonSchema(s.v, id, s.title, s.required)
+		if err != nil {
+			return err
+		}
+		if err = c.WriteOutput(s.name, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// JsonSchema builds a JSON Schema (draft 2020-12) document describing how encoding/json marshals v. Named structs
+// are placed in $defs so that recursive types (such as the per-address results) can be described. Fields without
+// omitempty are required, and pointers, slices, and maps without omitempty may be null.
+func JsonSchema(v interface{}, id string, title string) ([]byte, error) {
+	return jsonSchema(v, id, title, nil)
+}
+
+// jsonSchema is JsonSchema, but if required is not nil only the fields it lists for each type are required
+func jsonSchema(v interface{}, id string, title string, required map[string][]string) ([]byte, error) {
+	g := &schemaGen{defs: make(map[string]interface{}), required: required}
+	t := reflect.TypeOf(v)
+	var root map[string]interface{}
+	if t.Kind() == reflect.Struct && t != timeType {
+		root = g.object(t)
+	} else {
+		root = g.schema(t)
+	}
+	root["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	root["$id"] = id
+	root["title"] = title
+	if len(g.defs) > 0 {
+		root["$defs"] = g.defs
+	}
+	return json.MarshalIndent(root, "", "  ")
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+type schemaGen struct {
+	defs     map[string]interface{}
+	required map[string][]string
+}
+
+func (g *schemaGen) schema(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return g.schema(t.Elem())
+	case reflect.Struct:
+		if t == timeType {
+			return map[string]interface{}{"type": "string", "format": "date-time"}
+		}
+		if _, ok := g.defs[t.Name()]; !ok {
+			// reserve the name first, the struct may refer to itself
+			g.defs[t.Name()] = nil
+			g.defs[t.Name()] = g.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/$defs/" + t.Name()}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "contentEncoding": "base64"}
+		}
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	}
+	return map[string]interface{}{}
+}
+
+// object describes a struct, fields of embedded structs without a json name are included as encoding/json does.
+func (g *schemaGen) object(t reflect.Type) map[string]interface{} {
+	props := make(map[string]interface{})
+	required := make([]string, 0)
+	g.fields(t, props, &required)
+	if g.required != nil {
+		required = g.required[t.Name()]
+	}
+	o := map[string]interface{}{
+		"type":       "object",
+		"properties": props,
+	}
+	if len(required) > 0 {
+		o["required"] = required
+	}
+	return o
+}
+
+func (g *schemaGen) fields(t reflect.Type, props map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := strings.Split(f.Tag.Get("json"), ",")
+		if tag[0] == "-" {
+			continue
+		}
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && tag[0] == "" && ft.Kind() == reflect.Struct {
+			g.fields(ft, props, required)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		name := tag[0]
+		if name == "" {
+			name = f.Name
+		}
+		omit := false
+		for _, opt := range tag[1:] {
+			if opt == "omitempty" {
+				omit = true
+			}
+		}
+		s := g.schema(f.Type)
+		switch f.Type.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Map:
+			if !omit {
+				s = map[string]interface{}{"anyOf": []interface{}{s, map[string]interface{}{"type": "null"}}}
+			}
+		}
+		props[name] = s
+		if !omit {
+			*required = append(*required, name)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package fiohealth
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// files written before schema_version and the later fields were added
+var v1Files = map[string]string{
+	"schema/v1/report.json": `{"api":[{"type":"api","node":"https://fio.example.com","node_ver":"v2.0.0",
+		"timestamp":1600000000,"had_error":false,"error":"","error_for":"","request_latency_ms":120,
+		"head_block_latency_ms":400,"permissive_cors":true,"tls_ver_ok":true,"tls_cipher_ok":true,"tls_note":"",
+		"producer_exposed":false,"net_exposed":false,"from_geo":"US-VA"}],
+		"p2p":[{"type":"p2p","peer":"fio.example.com:9876","time_stamp":1600000000,"took_sec":2,"reachable":true,
+		"healthy":true,"head_block_latency_ms":300,"err_msg":"","from_geo":"US-VA"}],
+		"timestamp":"Sun Sep 13 12:26:40 UTC 2020","description":"Mainnet"}`,
+	"schema/v1/history-index.json": `[{"file":"1600000000.html","date":"Sun Sep 13 12:26:40 UTC 2020","from":"US-VA"}]`,
+	"schema/v1/api-alerts.json": `{"state":{"https://fio.example.com":{"health_alarm":true,"health_reason":"get info",
+		"health_not_before":"2020-09-13T16:26:40Z","security_alarm":false,"security_reason":"",
+		"security_not_before":"0001-01-01T00:00:00Z"}}}`,
+	"schema/v1/p2p-alerts.json": `{"State":{"fio.example.com:9876":{"alarm":false,"reason":"",
+		"not_before":"0001-01-01T00:00:00Z"}}}`,
+}
+
+func TestV1SchemaAcceptsOldFiles(t *testing.T) {
+	for _, s := range schemaFiles {
+		doc, ok := v1Files[s.name]
+		if !ok {
+			continue
+		}
+		b, err := jsonSchema(s.v, s.name, s.title, s.required)
+		if err != nil {
+			t.Fatal(err)
+		}
+		schema := make(map[string]interface{})
+		if err = json.Unmarshal(b, &schema); err != nil {
+			t.Fatal(err)
+		}
+		var v interface{}
+		if err = json.Unmarshal([]byte(doc), &v); err != nil {
+			t.Fatal(s.name, err)
+		}
+		if err = validate(schema, schema, v, "$"); err != nil {
+			t.Error(s.name, err)
+		}
+	}
+}
+
+func TestV2SchemaRequiresVersion(t *testing.T) {
+	b, err := JsonSchema(ReportV2{}, "report.json", "v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := make(map[string]interface{})
+	if err = json.Unmarshal(b, &schema); err != nil {
+		t.Fatal(err)
+	}
+	if err = validate(schema, schema, map[string]interface{}{}, "$"); err == nil || !strings.Contains(err.Error(), "schema_version") {
+		t.Errorf("expected schema_version to be required, got %v", err)
+	}
+}
+
+// validate checks v against the subset of JSON Schema that JsonSchema writes
+func validate(root map[string]interface{}, s map[string]interface{}, v interface{}, path string) error {
+	if ref, ok := s["$ref"].(string); ok {
+		def, _ := root["$defs"].(map[string]interface{})[strings.TrimPrefix(ref, "#/$defs/")].(map[string]interface{})
+		if def == nil {
+			return fmt.Errorf("%s: missing definition %s", path, ref)
+		}
+		return validate(root, def, v, path)
+	}
+	if anyOf, ok := s["anyOf"].([]interface{}); ok {
+		var err error
+		for _, alt := range anyOf {
+			if err = validate(root, alt.(map[string]interface{}), v, path); err == nil {
+				return nil
+			}
+		}
+		return err
+	}
+	switch s["type"] {
+	case "null":
+		if v != nil {
+			return fmt.Errorf("%s: expected null", path)
+		}
+	case "string":
+		if _, ok := v.(string); !ok {
+			return fmt.Errorf("%s: expected a string", path)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("%s: expected a boolean", path)
+		}
+	case "integer", "number":
+		n, ok := v.(float64)
+		if !ok || (s["type"] == "integer" && n != float64(int64(n))) {
+			return fmt.Errorf("%s: expected an %s", path, s["type"])
+		}
+	case "array":
+		a, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected an array", path)
+		}
+		for i, item := range a {
+			if err := validate(root, s["items"].(map[string]interface{}), item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case "object":
+		o, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected an object", path)
+		}
+		required, _ := s["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := o[name.(string)]; !ok {
+				return fmt.Errorf("%s: missing required %s", path, name)
+			}
+		}
+		props, _ := s["properties"].(map[string]interface{})
+		for k, item := range o {
+			var sub interface{}
+			if props != nil {
+				sub = props[k]
+			} else {
+				sub = s["additionalProperties"]
+			}
+			if sub == nil {
+				continue
+			}
+			if err := validate(root, sub.(map[string]interface{}), item, path+"."+k); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
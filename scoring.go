@@ -0,0 +1,183 @@
+package fiohealth
+
+import (
+	"errors"
+	"gopkg.in/yaml.v2"
+	"reflect"
+	"strings"
+)
+
+// Scoring holds the thresholds used to decide if a check has failed, and the weight each failure adds to a node's
+// score. Results are sorted by score so the worst nodes are listed first. Nodes can override any of the values,
+// anything not set uses the default, an explicit 0 is kept.
+type Scoring struct {
+	ScoringRules `yaml:",inline"`
+	Nodes        map[string]*ScoringRules `yaml:"nodes"`
+
+	decoded bool
+}
+
+// ScoringRules are the thresholds and weights for a node
+type ScoringRules struct {
+	HeadBlockLag   int            `yaml:"head_block_lag"`    // seconds
+	CertExpiryDays int            `yaml:"cert_expiry_days"`  // warn when any certificate in the chain expires within this many days
+	SlowResponse   int64          `yaml:"slow_response_ms"`  // highlighted in the report
+	MaxLibGap      uint32         `yaml:"max_lib_gap"`       // blocks LIB can trail head in a p2p handshake
+	MaxStreamGap   int64          `yaml:"max_stream_gap_ms"` // longest time between blocks when observing a p2p stream
+	Api            ApiWeights     `yaml:"api"`
+	P2p            P2pWeights     `yaml:"p2p"`
+	History        HistoryWeights `yaml:"history"`
+}
+
+// ApiWeights are added to an API node's score for each problem
+type ApiWeights struct {
+	Connection   float32 `yaml:"connection"`    // connecting or a required request failed
+	WrongChain   float32 `yaml:"wrong_chain"`   // chain id does not match
+	Lagging      float32 `yaml:"lagging"`       // head block is older than head_block_lag
+	Tls          float32 `yaml:"tls"`           // weak TLS versions or ciphers, or TLS not enabled
+	CertInvalid  float32 `yaml:"cert_invalid"`  // revoked, untrusted, or wrong hostname
+	CertWarning  float32 `yaml:"cert_warning"`  // any other certificate finding
+	CertExpiring float32 `yaml:"cert_expiring"` // leaf certificate expires within cert_expiry_days
+	Cors         float32 `yaml:"cors"`          // CORS is not permissive
+	Header       float32 `yaml:"header"`        // each failed security header check
+	Exposed      float32 `yaml:"exposed"`       // each sensitive endpoint that is exposed
+}
+
+// P2pWeights are added to a peer's score for each problem
+type P2pWeights struct {
+	Connection float32 `yaml:"connection"`  // connection failed, go away, or no blocks received
+	Lagging    float32 `yaml:"lagging"`     // head block is older than head_block_lag, or LIB is stale
+	WrongChain float32 `yaml:"wrong_chain"` // chain id in handshake does not match
+	Stream     float32 `yaml:"stream"`      // missing blocks or gaps in the observed block stream
+	Sync       float32 `yaml:"sync"`        // did not serve all requested blocks when syncing
+}
+
+// HistoryWeights are added to a history node's score for each problem
+type HistoryWeights struct {
+	Connection float32 `yaml:"connection"` // connecting or a required request failed
+	Lagging    float32 `yaml:"lagging"`    // newest action is older than history_max_lag
+}
+
+// DefaultScoring returns the thresholds and weights used for anything not set in the config
+func DefaultScoring() ScoringRules {
+	return ScoringRules{
+		HeadBlockLag:   30,
+		CertExpiryDays: 30,
+		SlowResponse:   2000,
+		MaxLibGap:      1000,
+		MaxStreamGap:   7000,
+		Api: ApiWeights{
+			Connection:   10,
+			WrongChain:   5,
+			Lagging:      1,
+			Tls:          1,
+			CertInvalid:  3,
+			CertWarning:  1,
+			CertExpiring: .1,
+			Cors:         1,
+			Header:       .1,
+			Exposed:      3,
+		},
+		P2p: P2pWeights{
+			Connection: 1,
+			Lagging:    1,
+			WrongChain: 1,
+			Stream:     1,
+			Sync:       1,
+		},
+		History: HistoryWeights{
+			Connection: 10,
+			Lagging:    1,
+		},
+	}
+}
+
+// Rules gives the scoring rules for a node, using the node's overrides if there are any
+func (c *Config) Rules(node string) *ScoringRules {
+	if rules := c.Scoring.Nodes[node]; rules != nil {
+		return rules
+	}
+	return &c.Scoring.ScoringRules
+}
+
+// UnmarshalYAML starts from the defaults so that only the settings present in the config are replaced, the overrides
+// for each node start from the resulting rules.
+func (s *Scoring) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	raw := struct {
+		ScoringRules `yaml:",inline"`
+		Nodes        map[string]yaml.MapSlice `yaml:"nodes"`
+	}{ScoringRules: DefaultScoring()}
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+	s.ScoringRules = raw.ScoringRules
+	s.Nodes = make(map[string]*ScoringRules)
+	for node, settings := range raw.Nodes {
+		if settings == nil {
+			s.Nodes[node] = nil
+			continue
+		}
+		b, err := yaml.Marshal(settings)
+		if err != nil {
+			return err
+		}
+		rules := s.ScoringRules
+		if err = yaml.Unmarshal(b, &rules); err != nil {
+			return errors.New("scoring.nodes[" + node + "]: " + err.Error())
+		}
+		s.Nodes[node] = &rules
+	}
+	s.decoded = true
+	return nil
+}
+
+// validate checks that nothing is negative, uses the defaults if there was no scoring section, and ensures per-node
+// rules are for a configured node.
+func (s *Scoring) validate(nodes []string) error {
+	if !s.decoded {
+		s.ScoringRules = DefaultScoring()
+	}
+	if err := checkNegative(reflect.ValueOf(s.ScoringRules), "scoring"); err != nil {
+		return err
+	}
+	known := make(map[string]bool)
+	for _, n := range nodes {
+		known[n] = true
+	}
+	for node, rules := range s.Nodes {
+		if !known[node] {
+			return errors.New("scoring rules for unknown node " + node)
+		}
+		if rules == nil {
+			s.Nodes[node] = &s.ScoringRules
+			continue
+		}
+		if err := checkNegative(reflect.ValueOf(*rules), "scoring.nodes["+node+"]"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkNegative returns an error naming the first setting that is less than zero
+func checkNegative(v reflect.Value, path string) error {
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		name := path + "." + strings.Split(v.Type().Field(i).Tag.Get("yaml"), ",")[0]
+		switch f.Kind() {
+		case reflect.Struct:
+			if err := checkNegative(f, name); err != nil {
+				return err
+			}
+		case reflect.Int, reflect.Int64:
+			if f.Int() < 0 {
+				return errors.New(name + " cannot be negative")
+			}
+		case reflect.Float32:
+			if f.Float() < 0 {
+				return errors.New(name + " cannot be negative")
+			}
+		}
+	}
+	return nil
+}
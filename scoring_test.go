@@ -0,0 +1,74 @@
+package fiohealth
+
+import (
+	"gopkg.in/yaml.v2"
+	"testing"
+)
+
+func TestScoringExplicitZero(t *testing.T) {
+	y := `
+scoring:
+  slow_response_ms: 3000
+  api:
+    header: 0
+    cors: 0
+  nodes:
+    https://a.example.com:
+      api:
+        exposed: 0
+    https://b.example.com:
+`
+	c := &Config{}
+	if err := yaml.Unmarshal([]byte(y), c); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Scoring.validate([]string{"https://a.example.com", "https://b.example.com", "https://c.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	defaults := DefaultScoring()
+
+	rules := c.Rules("https://c.example.com")
+	if rules.Api.Header != 0 || rules.Api.Cors != 0 {
+		t.Errorf("explicit 0 weights were replaced: header %v, cors %v", rules.Api.Header, rules.Api.Cors)
+	}
+	if rules.SlowResponse != 3000 {
+		t.Errorf("slow_response_ms should be 3000, got %d", rules.SlowResponse)
+	}
+	if rules.Api.Exposed != defaults.Api.Exposed || rules.HeadBlockLag != defaults.HeadBlockLag {
+		t.Error("unset values should use the defaults")
+	}
+
+	a := c.Rules("https://a.example.com")
+	if a.Api.Exposed != 0 {
+		t.Errorf("explicit 0 node override was replaced: %v", a.Api.Exposed)
+	}
+	if a.Api.Header != 0 || a.SlowResponse != 3000 || a.Api.Connection != defaults.Api.Connection {
+		t.Error("node overrides should start from the top level rules")
+	}
+	if b := c.Rules("https://b.example.com"); b != rules {
+		t.Error("a node without overrides should use the top level rules")
+	}
+}
+
+func TestScoringDefaults(t *testing.T) {
+	c := &Config{}
+	if err := yaml.Unmarshal([]byte("report_title: test\n"), c); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Scoring.validate(nil); err != nil {
+		t.Fatal(err)
+	}
+	if *c.Rules("https://a.example.com") != DefaultScoring() {
+		t.Error("the defaults should be used without a scoring section")
+	}
+}
+
+func TestScoringNegative(t *testing.T) {
+	c := &Config{}
+	if err := yaml.Unmarshal([]byte("scoring:\n  api:\n    cors: -1\n"), c); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Scoring.validate(nil); err == nil {
+		t.Error("a negative weight should be an error")
+	}
+}